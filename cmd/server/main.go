@@ -1,11 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/Myspheet/go-rate-limiter/internal/middleware"
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
 	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
 )
 
@@ -32,22 +41,138 @@ func main() {
 	// 	WindowDuration: time.Minute,
 	// })
 
+	// swc := bucket.NewInMemoryBucket[bucket.SlidingWindowCounterBucketType]()
+	// limiter := limiter.NewSlidingWindowCounterLimiter(swc, limiter.SlidingWindowCounterConfig{
+	// 	WindowDuration: time.Minute,
+	// 	WindowTokens:   5,
+	// })
+
+	// gcra := bucket.NewInMemoryBucket[bucket.GCRABucketType]()
+	// limiter := limiter.NewGCRALimiter(gcra, limiter.GCRAConfig{
+	// 	Rate:   5,
+	// 	Period: time.Minute,
+	// 	Burst:  5,
+	// })
+
+	// share the limit between replicas through Redis
+	// rb := bucket.NewRedisBucket[bucket.GCRABucketType]("localhost:6379", bucket.WithKeyPrefix("hello:"))
+	// defer rb.Close()
+	// limiter := limiter.NewGCRALimiter(rb, limiter.GCRAConfig{
+	// 	Rate:   5,
+	// 	Period: time.Minute,
+	// })
+
 	// new limiter
-	limiter, err := limiter.NewRateLimiter("fixed_window", map[string]any{
-		"window_duration": time.Minute,
-		"window_tokens":   5,
-		"window_size":     1,
+	// limiter, err := limiter.NewRateLimiter("fixed_window", map[string]any{
+	// 	"window_duration": time.Minute,
+	// 	"window_tokens":   5,
+	// 	"window_size":     1,
+	// })
+
+	// keep the limits across restarts, the state is restored on boot and
+	// snapshotted on graceful shutdown
+	snapshotPath := flag.String("snapshot", "rate_limit.snapshot", "file to keep the limiter state in across restarts")
+	trustedProxies := flag.String("trusted-proxies", "", "comma separated CIDRs of proxies whose X-Forwarded-For is trusted")
+	allowListPath := flag.String("allow-list", "", "file of CIDRs and keys never rate limited, reloaded on SIGHUP")
+	denyListPath := flag.String("deny-list", "", "file of CIDRs and keys always blocked, reloaded on SIGHUP")
+	flag.Parse()
+
+	fw := bucket.NewInMemoryBucket[bucket.FixedWindowBucketType](bucket.WithJanitor(time.Minute))
+	defer fw.Close()
+
+	if n, err := fw.LoadSnapshot(*snapshotPath); err != nil {
+		log.Printf("failed to restore the limiter state: %v", err)
+	} else if n > 0 {
+		log.Printf("restored the limiter state of %d keys", n)
+	}
+
+	limiter := limiter.NewFixedWindowLimiter(fw, limiter.FixedWindowConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   5,
+		WindowSize:     1,
 	})
-	if err != nil {
-		panic(err)
+
+	// limit by client IP, looking through our load balancers if configured
+	var resolver *middleware.ClientIPResolver
+	if *trustedProxies != "" {
+		var err error
+		resolver, err = middleware.NewClientIPResolver(middleware.XForwardedFor, strings.Split(*trustedProxies, ",")...)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	opts := []middleware.Option{
+		middleware.WithKeyFunc(middleware.ClientIPKey(middleware.WithResolver(resolver))),
+		middleware.WithListResolver(resolver),
 	}
 
-	rl := middleware.NewRateLimiter(limiter)
+	// allow and deny lists, edit the files and send SIGHUP to reload them
+	type listFile struct {
+		path string
+		list *middleware.AccessList
+	}
+	var lists []listFile
+	for _, l := range []struct {
+		path string
+		with func(*middleware.AccessList) middleware.Option
+	}{
+		{*allowListPath, middleware.WithAllowList},
+		{*denyListPath, middleware.WithDenyList},
+	} {
+		if l.path == "" {
+			continue
+		}
+		list, _ := middleware.NewAccessList()
+		if err := list.LoadFile(l.path); err != nil {
+			log.Fatalf("failed to load %s: %v", l.path, err)
+		}
+		lists = append(lists, listFile{path: l.path, list: list})
+		opts = append(opts, l.with(list))
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			for _, l := range lists {
+				if err := l.list.LoadFile(l.path); err != nil {
+					log.Printf("failed to reload %s, keeping the old list: %v", l.path, err)
+					continue
+				}
+				log.Printf("reloaded %s with %d entries", l.path, l.list.Len())
+			}
+		}
+	}()
+
+	rl := middleware.NewRateLimiter(limiter, opts...)
 
 	helloHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "Hello, %s!", r.URL.Path[1:])
 	})
 
 	http.Handle("/", rl.Middleware(helloHandler))
-	http.ListenAndServe(":8080", nil)
+	server := &http.Server{Addr: ":8080"}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("server failed: %v", err)
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("failed to shut down gracefully: %v", err)
+	}
+
+	if err := fw.SaveSnapshot(*snapshotPath); err != nil {
+		log.Printf("failed to snapshot the limiter state: %v", err)
+	}
 }
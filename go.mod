@@ -1,3 +1,5 @@
 module github.com/Myspheet/go-rate-limiter
 
 go 1.25.1
+
+require github.com/yuin/gopher-lua v1.1.1
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// AccessList is a set of networks and keys, used to let clients bypass the
+// limits or to block them, see WithAllowList and WithDenyList.
+//
+// Entries that parse as a CIDR or a single address are networks, anything
+// else is a key as returned by the middleware's KeyFunc, e.g.
+// "header:X-Api-Key=partner" or a bare API key like "deadbeef". A mistyped
+// network such as "10.0.0.0/33" is taken for a key and matches nothing. The
+// entries can be replaced at any time, requests in flight see either the old
+// or the new ones.
+type AccessList struct {
+	entries atomic.Pointer[accessEntries]
+}
+
+// accessEntries is an immutable snapshot of an AccessList. Networks are
+// grouped by prefix length, so an address is matched with one map lookup per
+// distinct length instead of one comparison per network.
+type accessEntries struct {
+	networks map[netip.Prefix]struct{}
+	v4Bits   []int // distinct prefix lengths of the IPv4 networks
+	v6Bits   []int // distinct prefix lengths of the IPv6 networks
+	keys     map[string]struct{}
+}
+
+// NewAccessList creates an AccessList holding entries
+func NewAccessList(entries ...string) (*AccessList, error) {
+	list := &AccessList{}
+	if err := list.Reload(entries...); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Reload replaces the entries of the list. The list is left untouched if an
+// entry is empty.
+func (l *AccessList) Reload(entries ...string) error {
+	parsed := &accessEntries{
+		networks: make(map[netip.Prefix]struct{}),
+		keys:     make(map[string]struct{}),
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			return fmt.Errorf("Empty access list entry")
+		}
+		prefix, err := parsePrefix(entry)
+		if err != nil {
+			parsed.keys[entry] = struct{}{}
+			continue
+		}
+		parsed.networks[prefix] = struct{}{}
+		if prefix.Addr().Is4() {
+			parsed.v4Bits = append(parsed.v4Bits, prefix.Bits())
+		} else {
+			parsed.v6Bits = append(parsed.v6Bits, prefix.Bits())
+		}
+	}
+	slices.Sort(parsed.v4Bits)
+	parsed.v4Bits = slices.Compact(parsed.v4Bits)
+	slices.Sort(parsed.v6Bits)
+	parsed.v6Bits = slices.Compact(parsed.v6Bits)
+
+	l.entries.Store(parsed)
+	return nil
+}
+
+// LoadFile replaces the entries of the list with those in the file at path,
+// one per line. Blank lines and everything after a # are ignored.
+func (l *AccessList) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return l.Reload(entries...)
+}
+
+// Len returns the number of networks and keys in the list
+func (l *AccessList) Len() int {
+	entries := l.entries.Load()
+	return len(entries.networks) + len(entries.keys)
+}
+
+// Contains reports whether addr is in one of the networks of the list
+func (l *AccessList) Contains(addr netip.Addr) bool {
+	entries := l.entries.Load()
+	addr = addr.Unmap().WithZone("")
+
+	bits := entries.v6Bits
+	if addr.Is4() {
+		bits = entries.v4Bits
+	}
+	for _, b := range bits {
+		prefix, err := addr.Prefix(b)
+		if err != nil {
+			continue
+		}
+		if _, ok := entries.networks[prefix]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsKey reports whether key is one of the keys of the list
+func (l *AccessList) ContainsKey(key string) bool {
+	_, ok := l.entries.Load().keys[key]
+	return ok
+}
+
+// WithAllowList lets requests from clients in list through without counting
+// them against any limit, e.g. health checkers or partners. The deny list
+// takes precedence.
+func WithAllowList(list *AccessList) Option {
+	return func(rl *RateLimiter) {
+		rl.allowList = list
+	}
+}
+
+// WithDenyList rejects requests from clients in list with 403 Forbidden
+// before they reach any limit.
+func WithDenyList(list *AccessList) Option {
+	return func(rl *RateLimiter) {
+		rl.denyList = list
+	}
+}
+
+// WithListResolver resolves the client's address matched against the allow
+// and deny lists through trusted proxies. By default the peer of the
+// connection is the client.
+func WithListResolver(resolver *ClientIPResolver) Option {
+	return func(rl *RateLimiter) {
+		rl.listResolver = resolver
+	}
+}
+
+// listed reports whether r comes from a client in list, by address or by the
+// key it is limited under
+func (rl *RateLimiter) listed(list *AccessList, r *http.Request) bool {
+	if list == nil {
+		return false
+	}
+	resolver := rl.listResolver
+	if resolver == nil {
+		resolver = &ClientIPResolver{}
+	}
+	if addr, ok := resolver.ClientIP(r); ok && list.Contains(addr) {
+		return true
+	}
+	key, ok := rl.key(r)
+	return ok && list.ContainsKey(key)
+}
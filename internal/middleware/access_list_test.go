@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	list, err := NewAccessList("10.0.0.0/8", "192.0.2.7", "2001:db8::/32", "::ffff:198.51.100.0/120", "header:X-Api-Key=partner")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"11.0.0.1", false},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"2001:db8:1::1", true},
+		{"2001:db9::1", false},
+		{"198.51.100.42", true},
+		{"::ffff:10.0.0.1", true},
+		{"fe80::1%eth0", false},
+	}
+	for _, tt := range tests {
+		if got := list.Contains(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.addr, tt.want, got)
+		}
+	}
+
+	if !list.ContainsKey("header:X-Api-Key=partner") || list.ContainsKey("header:X-Api-Key=other") {
+		t.Error("expected only the listed key to match")
+	}
+	if got := list.Len(); got != 5 {
+		t.Errorf("expected 5 entries, got %d", got)
+	}
+}
+
+func TestAccessList_Keys(t *testing.T) {
+	// keys that look a lot like addresses
+	list, err := NewAccessList("deadbeef", "cafe", "abc123", "10.0.0.0/33", "beef:cafe", "2001:db8::/64")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"deadbeef", "cafe", "abc123", "10.0.0.0/33", "beef:cafe"} {
+		if !list.ContainsKey(key) {
+			t.Errorf("expected %q to be a key", key)
+		}
+	}
+	if list.ContainsKey("2001:db8::/64") || !list.Contains(netip.MustParseAddr("2001:db8::1")) {
+		t.Error("expected the CIDR to be a network")
+	}
+	if list.Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("expected the malformed network to match no address")
+	}
+}
+
+func TestAccessList_Reload(t *testing.T) {
+	list, _ := NewAccessList("10.0.0.0/8")
+
+	if err := list.Reload("192.0.2.0/24", " "); err == nil {
+		t.Fatal("expected an error for an empty entry")
+	}
+	if !list.Contains(netip.MustParseAddr("10.0.0.1")) {
+		t.Error("expected a failed reload to keep the entries")
+	}
+
+	path := filepath.Join(t.TempDir(), "deny.list")
+	content := "# known bad ranges\n\n203.0.113.0/24 # abuse\nheader:X-Api-Key=leaked\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := list.LoadFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list.Contains(netip.MustParseAddr("10.0.0.1")) || !list.Contains(netip.MustParseAddr("203.0.113.9")) {
+		t.Error("expected the entries to be replaced")
+	}
+	if !list.ContainsKey("header:X-Api-Key=leaked") {
+		t.Error("expected the key to be loaded")
+	}
+}
+
+func TestRateLimiter_AccessLists(t *testing.T) {
+	allow, _ := NewAccessList("192.0.2.0/24", "header:X-Api-Key=partner")
+	deny, _ := NewAccessList("203.0.113.0/24", "192.0.2.66")
+	rl := NewRateLimiter(newFixedWindow(t, 1),
+		WithKeyFunc(FirstKey(HeaderKey("X-Api-Key"), ClientIPKey())),
+		WithAllowList(allow),
+		WithDenyList(deny),
+	)
+	handler := rl.Middleware(okHandler)
+
+	send := func(remoteAddr, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = remoteAddr
+		if apiKey != "" {
+			r.Header.Set("X-Api-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := send("192.0.2.1:1", ""); w.Code != http.StatusOK || w.Header().Get("RateLimit") != "" {
+			t.Errorf("request %d: expected the allowed network to bypass the limit, got %d", i, w.Code)
+		}
+		if w := send("198.51.100.1:1", "partner"); w.Code != http.StatusOK {
+			t.Errorf("request %d: expected the allowed key to bypass the limit, got %d", i, w.Code)
+		}
+	}
+
+	// the deny list wins over the allow list
+	for _, addr := range []string{"203.0.113.5:1", "192.0.2.66:1"} {
+		if w := send(addr, ""); w.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status 403, got %d", addr, w.Code)
+		}
+	}
+
+	if w := send("198.51.100.1:1", ""); w.Code != http.StatusOK {
+		t.Errorf("expected other clients to be limited as usual, got %d", w.Code)
+	}
+	if w := send("198.51.100.1:1", ""); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected other clients to be limited as usual, got %d", w.Code)
+	}
+}
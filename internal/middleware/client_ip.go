@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ProxyHeader is the header a trusted proxy reports the client's address in
+type ProxyHeader int
+
+const (
+	// XForwardedFor is the de facto standard X-Forwarded-For header, a comma
+	// separated list of addresses every proxy appends its peer to
+	XForwardedFor ProxyHeader = iota
+	// XRealIP is the X-Real-IP header holding the single client address
+	XRealIP
+	// Forwarded is the RFC 7239 Forwarded header, whose for= parameters list
+	// the addresses the same way X-Forwarded-For does
+	Forwarded
+)
+
+// ClientIPResolver finds the address of the client behind a chain of trusted
+// proxies. Headers are only believed if the request arrives from a trusted
+// proxy, and only as far as the chain of trusted proxies goes, so a client
+// can't spoof its address by sending the header itself.
+//
+// Only the header the proxies actually set should be configured: a proxy
+// passing on other headers untouched would let clients pick their address.
+type ClientIPResolver struct {
+	header  ProxyHeader
+	trusted []netip.Prefix
+}
+
+// NewClientIPResolver creates a ClientIPResolver reading header from the
+// proxies in trustedProxies, given as CIDRs or single addresses.
+func NewClientIPResolver(header ProxyHeader, trustedProxies ...string) (*ClientIPResolver, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		prefix, err := parsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy %q: %w", proxy, err)
+		}
+		trusted = append(trusted, prefix)
+	}
+
+	return &ClientIPResolver{
+		header:  header,
+		trusted: trusted,
+	}, nil
+}
+
+// ClientIP returns the address of the client that sent r. It is the peer of
+// the connection unless that is a trusted proxy, in which case the proxy
+// header is walked from the nearest hop outwards, skipping trusted proxies,
+// up to the first untrusted address. If the chain breaks before that, on an
+// obfuscated identifier or a malformed entry, the last trusted hop is the
+// client as far as we can tell. ok is false if RemoteAddr isn't an address.
+func (res *ClientIPResolver) ClientIP(r *http.Request) (addr netip.Addr, ok bool) {
+	peer, ok := parseAddr(r.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	if !res.isTrusted(peer) {
+		return peer, true
+	}
+
+	hops := res.hops(r)
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseAddr(hops[i])
+		if !ok {
+			return client, true
+		}
+		client = hop
+		if !res.isTrusted(hop) {
+			break
+		}
+	}
+	return client, true
+}
+
+// hops returns the addresses listed in the proxy header, from the client to
+// the nearest proxy
+func (res *ClientIPResolver) hops(r *http.Request) []string {
+	switch res.header {
+	case XRealIP:
+		values := r.Header.Values("X-Real-Ip")
+		if len(values) != 1 {
+			// a repeated header can't be told apart from a spoofed one
+			return []string{""}
+		}
+		return []string{strings.TrimSpace(values[0])}
+	case Forwarded:
+		return forwardedFor(r.Header.Values("Forwarded"))
+	default:
+		var hops []string
+		for _, value := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return hops
+	}
+}
+
+func (res *ClientIPResolver) isTrusted(addr netip.Addr) bool {
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the for= parameters of RFC 7239 Forwarded headers, one
+// per forwarded element. An element without a for= parameter, with a
+// malformed one or with several yields an empty string, which breaks the chain.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, value := range values {
+		for _, element := range splitQuoted(value, ',') {
+			if strings.TrimSpace(element) == "" {
+				continue
+			}
+
+			hop, seen := "", false
+			for _, pair := range splitQuoted(element, ';') {
+				name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(name), "for") {
+					continue
+				}
+				if seen {
+					// a repeated parameter makes the element invalid
+					hop = ""
+					break
+				}
+				hop, seen = unquote(strings.TrimSpace(value)), true
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s at every sep outside of a quoted string
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns the value of an RFC 7230 token or quoted-string. A quoted
+// string that isn't terminated yields an empty string.
+func unquote(value string) string {
+	if !strings.HasPrefix(value, `"`) {
+		return value
+	}
+	if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+		return ""
+	}
+
+	var sb strings.Builder
+	escaped := false
+	for _, c := range value[1 : len(value)-1] {
+		if !escaped && c == '\\' {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// parseAddr parses an address as found in RemoteAddr and proxy headers: a
+// plain IPv4 or IPv6 address, optionally in brackets or with a port.
+// IPv4-mapped IPv6 addresses are unmapped and zones dropped.
+func parseAddr(s string) (netip.Addr, bool) {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		addrPort, err := netip.ParseAddrPort(s)
+		if err != nil {
+			if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+				return netip.Addr{}, false
+			}
+			if addr, err = netip.ParseAddr(s[1 : len(s)-1]); err != nil {
+				return netip.Addr{}, false
+			}
+		} else {
+			addr = addrPort.Addr()
+		}
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// parsePrefix parses a CIDR or a single address, the latter as a prefix
+// holding just that address
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,380 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewClientIPResolver_InvalidProxy(t *testing.T) {
+	for _, proxy := range []string{"", "10.0.0.0/33", "not-an-ip", "10.0.0.1:80", "2001:db8::/129"} {
+		if _, err := NewClientIPResolver(XForwardedFor, proxy); err == nil {
+			t.Errorf("expected an error for trusted proxy %q", proxy)
+		}
+	}
+}
+
+func TestClientIPResolver_ClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.1"}
+
+	tests := []struct {
+		name       string
+		header     ProxyHeader
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+		wantOK     bool
+	}{
+		// the peer is the client unless it is a trusted proxy
+		{
+			name:       "untrusted peer without header",
+			remoteAddr: "203.0.113.7:4711",
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "untrusted peer spoofing the header",
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "untrusted ipv6 peer",
+			remoteAddr: "[2001:db8::1]:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1"}},
+			want:       "2001:db8::1",
+			wantOK:     true,
+		},
+		{
+			name:       "ipv4-mapped peer",
+			remoteAddr: "[::ffff:203.0.113.7]:443",
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "peer without port",
+			remoteAddr: "203.0.113.7",
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "malformed peer",
+			remoteAddr: "pipe",
+			wantOK:     false,
+		},
+
+		// X-Forwarded-For
+		{
+			name:       "trusted peer without header",
+			remoteAddr: "10.0.0.1:4711",
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "single hop",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, 192.0.2.1, 10.1.2.3"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "client prepends a spoofed address",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1, 203.0.113.7, 10.1.2.3"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "client spoofs a trusted address",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.9.9.9, 203.0.113.7"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "header spread over several lines",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"198.51.100.1", "203.0.113.7, 10.1.2.3"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "every hop trusted",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.3.3.3, 10.2.2.2"}},
+			want:       "10.3.3.3",
+			wantOK:     true,
+		},
+		{
+			name:       "ipv6 hops",
+			remoteAddr: "[2001:db8:ffff::1]:443",
+			headers:    map[string][]string{"X-Forwarded-For": {"2001:db8:1::7, 2001:db8:ffff::2"}},
+			want:       "2001:db8:1::7",
+			wantOK:     true,
+		},
+		{
+			name:       "hops with ports and brackets",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"[2001:db8:1::7]:1234, 192.0.2.1:80"}},
+			want:       "2001:db8:1::7",
+			wantOK:     true,
+		},
+		{
+			name:       "hop with zone",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"fe80::1%eth0"}},
+			want:       "fe80::1",
+			wantOK:     true,
+		},
+		{
+			name:       "ipv4-mapped hop",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"::ffff:203.0.113.7"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "malformed hop stops at the last trusted hop",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7, garbage, 10.1.2.3"}},
+			want:       "10.1.2.3",
+			wantOK:     true,
+		},
+		{
+			name:       "empty hop",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.7,,"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "out of range octet",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Forwarded-For": {"203.0.113.256"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "other header ignored",
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.7"}, "Forwarded": {"for=203.0.113.8"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+
+		// X-Real-IP
+		{
+			name:       "real ip",
+			header:     XRealIP,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Real-Ip": {" 203.0.113.7 "}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "real ip ipv6",
+			header:     XRealIP,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Real-Ip": {"2001:db8:1::7"}},
+			want:       "2001:db8:1::7",
+			wantOK:     true,
+		},
+		{
+			name:       "real ip from untrusted peer",
+			header:     XRealIP,
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string][]string{"X-Real-Ip": {"198.51.100.1"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "repeated real ip",
+			header:     XRealIP,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Real-Ip": {"198.51.100.1", "203.0.113.7"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "malformed real ip",
+			header:     XRealIP,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.7, 198.51.100.1"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+
+		// Forwarded
+		{
+			name:       "forwarded",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=203.0.113.7;proto=https;by=10.0.0.1"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded is case insensitive",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"For=203.0.113.7"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded quoted ipv6 with port",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for="[2001:db8:cafe::17]:4711"`}},
+			want:       "2001:db8:cafe::17",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded quoted ipv4 with port",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for="203.0.113.7:4711"`}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded chain",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1, for=203.0.113.7;proto=http, for=192.0.2.1"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded over several lines",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1", "for=203.0.113.7"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded separators inside quotes",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for=203.0.113.7;host="a,b;c=d"`}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded escaped quote",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for=203.0.113.7;ext="say \"hi\", bye"`}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded obfuscated identifier",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=_hidden, for=192.0.2.1"}},
+			want:       "192.0.2.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded unknown",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=unknown"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded obfuscated port",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for="203.0.113.7:_abc"`}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded element without for",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=203.0.113.7, proto=https"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded repeated for",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {"for=203.0.113.7;for=198.51.100.1"}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded unterminated quote",
+			header:     Forwarded,
+			remoteAddr: "10.0.0.1:4711",
+			headers:    map[string][]string{"Forwarded": {`for="203.0.113.7`}},
+			want:       "10.0.0.1",
+			wantOK:     true,
+		},
+		{
+			name:       "forwarded from untrusted peer",
+			header:     Forwarded,
+			remoteAddr: "203.0.113.7:4711",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1"}},
+			want:       "203.0.113.7",
+			wantOK:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := NewClientIPResolver(tt.header, trusted...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+
+			got, ok := resolver.ClientIP(r)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok to be %v, got %v", tt.wantOK, ok)
+			}
+			if ok && got.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClientIPKey_WithResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver(XForwardedFor, "10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:4711"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7")
+
+	if key, ok := ClientIPKey(WithResolver(resolver))(r); !ok || key != "ip:203.0.113.7" {
+		t.Errorf("expected the forwarded client, got %q, %v", key, ok)
+	}
+	if key, ok := ClientIPKey()(r); !ok || key != "ip:10.0.0.1" {
+		t.Errorf("expected the peer without a resolver, got %q, %v", key, ok)
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// StatusPredicate reports whether a response with the given status code
+// counts against the limit
+type StatusPredicate func(status int) bool
+
+// WithCountIf only charges requests whose response matches countIf, e.g.
+// failed logins or upstream errors. The limit is still checked before the
+// handler runs, so a key that used up its limit is rejected either way; the
+// request's cost is held while it runs and given back if the response doesn't
+// count.
+func WithCountIf(countIf StatusPredicate) Option {
+	return func(rl *RateLimiter) {
+		rl.countIf = countIf
+	}
+}
+
+// StatusIn counts responses with any of the given status codes, e.g. 401 and
+// 403 for brute force protection.
+func StatusIn(codes ...int) StatusPredicate {
+	return func(status int) bool {
+		for _, code := range codes {
+			if status == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// StatusServerError counts 5xx responses
+func StatusServerError(status int) bool {
+	return status >= 500 && status <= 599
+}
+
+// StatusClientError counts 4xx responses
+func StatusClientError(status int) bool {
+	return status >= 400 && status <= 499
+}
+
+// statusWriter records the status code of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	// informational responses are followed by the real one
+	if sw.status == 0 && (status >= 200 || status == http.StatusSwitchingProtocols) {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush lets handlers stream through the wrapper
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// Status returns the status code of the response, 200 if the handler wrote
+// nothing
+func (sw *statusWriter) Status() int {
+	if sw.status == 0 {
+		return http.StatusOK
+	}
+	return sw.status
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter_CountIf(t *testing.T) {
+	// 2 failed logins per client
+	login := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("password") != "secret" {
+			http.Error(w, "wrong password", http.StatusUnauthorized)
+		}
+	})
+	handler := NewRateLimiter(newFixedWindow(t, 2), WithCountIf(StatusIn(http.StatusUnauthorized, http.StatusForbidden))).Middleware(login)
+
+	send := func(password string) int {
+		r := httptest.NewRequest(http.MethodPost, "/login?password="+password, nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 5; i++ {
+		if code := send("secret"); code != http.StatusOK {
+			t.Fatalf("request %d: expected successful logins not to count, got %d", i, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := send("guess"); code != http.StatusUnauthorized {
+			t.Fatalf("request %d: expected status 401, got %d", i, code)
+		}
+	}
+	if code := send("guess"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the failed logins to use up the limit, got %d", code)
+	}
+	// the limit is checked before the handler runs
+	if code := send("secret"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the limit to apply to every request, got %d", code)
+	}
+}
+
+func TestRateLimiter_CountIfServerError(t *testing.T) {
+	fail := false
+	upstream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("expected the response writer to stay a http.Flusher")
+		}
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	handler := NewRateLimiter(newFixedWindow(t, 1), WithCountIf(StatusServerError)).Middleware(upstream)
+
+	for i := 0; i < 3; i++ {
+		if code := serve(handler, "192.0.2.1:1"); code != http.StatusOK {
+			t.Fatalf("request %d: expected status 200, got %d", i, code)
+		}
+	}
+
+	fail = true
+	if code := serve(handler, "192.0.2.1:1"); code != http.StatusBadGateway {
+		t.Fatalf("expected status 502, got %d", code)
+	}
+	if code := serve(handler, "192.0.2.1:1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the upstream error to count, got %d", code)
+	}
+}
+
+func TestStatusWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+		want  int
+	}{
+		{"nothing written", func(w http.ResponseWriter) {}, http.StatusOK},
+		{"body only", func(w http.ResponseWriter) { w.Write([]byte("hi")) }, http.StatusOK},
+		{"status", func(w http.ResponseWriter) { w.WriteHeader(http.StatusForbidden) }, http.StatusForbidden},
+		{"status once", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNotFound)
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusNotFound},
+		{"informational first", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusUnauthorized)
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+			tt.write(sw)
+			if got := sw.Status(); got != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// HeaderStyle selects the rate limit headers the middleware sets on every
+// response. Styles can be combined, e.g. HeadersIETF | HeadersLegacy.
+type HeaderStyle int
+
+const (
+	// HeadersIETF sets the RateLimit and RateLimit-Policy headers of the IETF
+	// draft "RateLimit header fields for HTTP"
+	HeadersIETF HeaderStyle = 1 << iota
+	// HeadersLegacy sets X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset, the latter in Unix seconds
+	HeadersLegacy
+
+	// HeadersNone sets no rate limit headers, Retry-After is still set on
+	// rejected requests
+	HeadersNone HeaderStyle = 0
+)
+
+// WithHeaders sets the rate limit headers set on every response. The default
+// is HeadersIETF.
+func WithHeaders(style HeaderStyle) Option {
+	return func(rl *RateLimiter) {
+		rl.headers = style
+	}
+}
+
+// WithPolicyName sets the name the limit is reported under in the IETF
+// headers. The default is "default".
+func WithPolicyName(name string) Option {
+	return func(rl *RateLimiter) {
+		rl.policy = name
+	}
+}
+
+// writeHeaders reports the key's state in decision with the configured style
+func (rl *RateLimiter) writeHeaders(h http.Header, decision limiter.Decision, now time.Time) {
+	reset := max(ceilSeconds(decision.ResetAt.Sub(now)), 0)
+
+	if rl.headers&HeadersIETF != 0 {
+		policy := sfString(rl.policy) + ";q=" + strconv.Itoa(decision.Limit)
+		if decision.Window > 0 {
+			policy += ";w=" + strconv.FormatInt(ceilSeconds(decision.Window), 10)
+		}
+		h.Set("RateLimit-Policy", policy)
+		h.Set("RateLimit", sfString(rl.policy)+";r="+strconv.Itoa(decision.Remaining)+";t="+strconv.FormatInt(reset, 10))
+	}
+
+	if rl.headers&HeadersLegacy != 0 {
+		h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+reset, 10))
+	}
+}
+
+// writeRetryAfter sets Retry-After on a rejected request. It is left out if
+// the request can never be allowed, e.g. because it costs more than the limit.
+func writeRetryAfter(h http.Header, decision limiter.Decision) {
+	if decision.RetryAfter <= 0 {
+		return
+	}
+	h.Set("Retry-After", strconv.FormatInt(max(ceilSeconds(decision.RetryAfter), 1), 10))
+}
+
+// ceilSeconds returns d in whole seconds, rounded up so clients never come
+// back too early
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// sfString returns s as a structured field string (RFC 8941)
+func sfString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkRateLimit checks the RateLimit header reports remaining requests and a
+// reset within the hour long fixed window, and returns the reset
+func checkRateLimit(t *testing.T, h http.Header, policy string, remaining int) string {
+	t.Helper()
+	want := `"` + policy + `";r=` + strconv.Itoa(remaining) + ";t="
+	got := h.Get("RateLimit")
+	if !strings.HasPrefix(got, want) {
+		t.Errorf("expected RateLimit %q, got %q", want+"<reset>", got)
+		return ""
+	}
+	reset := strings.TrimPrefix(got, want)
+	if seconds, err := strconv.Atoi(reset); err != nil || seconds < 1 || seconds > 3600 {
+		t.Errorf("expected a reset within the window, got %q", got)
+	}
+	return reset
+}
+
+func TestRateLimiter_Headers(t *testing.T) {
+	handler := NewRateLimiter(newFixedWindow(t, 2)).Middleware(okHandler)
+
+	send := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "203.0.113.7:1"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := send()
+	if got, want := w.Header().Get("RateLimit-Policy"), `"default";q=2;w=3600`; got != want {
+		t.Errorf("expected RateLimit-Policy %q, got %q", want, got)
+	}
+	checkRateLimit(t, w.Header(), "default", 1)
+	if got := w.Header().Get("Retry-After"); got != "" {
+		t.Errorf("expected no Retry-After on an allowed request, got %q", got)
+	}
+	if got := w.Header().Get("X-RateLimit-Limit"); got != "" {
+		t.Errorf("expected no legacy headers by default, got %q", got)
+	}
+
+	send()
+	w = send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", w.Code)
+	}
+	reset := checkRateLimit(t, w.Header(), "default", 0)
+	if got, want := w.Header().Get("Retry-After"), reset; got != want {
+		t.Errorf("expected Retry-After %q, got %q", want, got)
+	}
+}
+
+func TestRateLimiter_HeaderStyles(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantIETF   bool
+		wantLegacy bool
+	}{
+		{"ietf", nil, true, false},
+		{"legacy", []Option{WithHeaders(HeadersLegacy)}, false, true},
+		{"both", []Option{WithHeaders(HeadersIETF | HeadersLegacy)}, true, true},
+		{"none", []Option{WithHeaders(HeadersNone)}, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewRateLimiter(newFixedWindow(t, 1), tt.opts...).Middleware(okHandler)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			handler.ServeHTTP(w, r)
+
+			if got := w.Header().Get("RateLimit") != ""; got != tt.wantIETF {
+				t.Errorf("expected IETF headers %v, got %v", tt.wantIETF, got)
+			}
+			if got := w.Header().Get("X-RateLimit-Limit") != ""; got != tt.wantLegacy {
+				t.Errorf("expected legacy headers %v, got %v", tt.wantLegacy, got)
+			}
+			if tt.wantLegacy {
+				reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+				if err != nil || reset < time.Now().Unix() {
+					t.Errorf("expected X-RateLimit-Reset in Unix seconds, got %q", w.Header().Get("X-RateLimit-Reset"))
+				}
+			}
+			// Retry-After is part of HTTP, not of a header style
+			if got := w.Header().Get("Retry-After"); got == "" {
+				t.Error("expected Retry-After on the rejected request")
+			}
+		})
+	}
+}
+
+func TestRateLimiter_HeadersReportTightestLayer(t *testing.T) {
+	rl := NewRateLimiter(newFixedWindow(t, 5),
+		WithLayer(newFixedWindow(t, 2), ClientIPKey(WithIPv4Prefix(24))),
+		WithPolicyName("api"),
+	)
+	handler := rl.Middleware(okHandler)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	checkRateLimit(t, w.Header(), "api", 1)
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// KeyFunc returns the key a request is limited under. ok is false if the
+// request carries nothing to derive the key from, e.g. a missing header.
+//
+// The built-in key functions prefix their keys with what they are derived
+// from, so different strategies never share a bucket by accident.
+type KeyFunc func(r *http.Request) (key string, ok bool)
+
+// unknownKey is the key requests are limited under if the KeyFunc can't
+// identify them; they share a single bucket instead of going unlimited
+const unknownKey = "unknown"
+
+// ClientIPOption configures ClientIPKey
+type ClientIPOption func(cfg *clientIPConfig)
+
+type clientIPConfig struct {
+	resolver   *ClientIPResolver
+	ipv4Prefix int
+	ipv6Prefix int
+}
+
+// WithResolver resolves the client's address through trusted proxies. By
+// default the peer of the connection is the client.
+func WithResolver(resolver *ClientIPResolver) ClientIPOption {
+	return func(cfg *clientIPConfig) {
+		cfg.resolver = resolver
+	}
+}
+
+// WithIPv6Prefix limits IPv6 clients by the network prefix of the given length
+// instead of their address, e.g. 64 or 56, since a single client usually has
+// a whole /64 or more to rotate through.
+func WithIPv6Prefix(bits int) ClientIPOption {
+	return func(cfg *clientIPConfig) {
+		cfg.ipv6Prefix = bits
+	}
+}
+
+// WithIPv4Prefix limits IPv4 clients by the network prefix of the given length
+// instead of their address, e.g. 24.
+func WithIPv4Prefix(bits int) ClientIPOption {
+	return func(cfg *clientIPConfig) {
+		cfg.ipv4Prefix = bits
+	}
+}
+
+// ClientIPKey limits requests by the IP address of the client, without the
+// port of its connection, or by its network prefix if configured.
+func ClientIPKey(opts ...ClientIPOption) KeyFunc {
+	cfg := clientIPConfig{
+		ipv4Prefix: 32,
+		ipv6Prefix: 128,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// without trusted proxies every peer is the client
+	resolver := cfg.resolver
+	if resolver == nil {
+		resolver = &ClientIPResolver{}
+	}
+
+	return func(r *http.Request) (string, bool) {
+		ip, ok := resolver.ClientIP(r)
+		if !ok {
+			return "", false
+		}
+
+		bits := cfg.ipv6Prefix
+		if ip.Is4() {
+			bits = cfg.ipv4Prefix
+		}
+		if bits <= 0 || bits >= ip.BitLen() {
+			return "ip:" + ip.String(), true
+		}
+
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			return "ip:" + ip.String(), true
+		}
+		return "ip:" + prefix.String(), true
+	}
+}
+
+// HeaderKey limits requests by the value of a header, e.g. an API key.
+func HeaderKey(name string) KeyFunc {
+	name = http.CanonicalHeaderKey(name)
+	return func(r *http.Request) (string, bool) {
+		value := r.Header.Get(name)
+		if value == "" {
+			return "", false
+		}
+		return "header:" + name + "=" + url.QueryEscape(value), true
+	}
+}
+
+// QueryKey limits requests by the value of a query parameter.
+func QueryKey(name string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return "", false
+		}
+		return "query:" + name + "=" + url.QueryEscape(value), true
+	}
+}
+
+// ContextKey limits requests by a value an earlier middleware stored in the
+// request's context, e.g. the authenticated user. The value has to be a
+// string or implement fmt.Stringer.
+func ContextKey(ctxKey any) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		var value string
+		switch v := r.Context().Value(ctxKey).(type) {
+		case string:
+			value = v
+		case fmt.Stringer:
+			value = v.String()
+		}
+		if value == "" {
+			return "", false
+		}
+		return "user:" + url.QueryEscape(value), true
+	}
+}
+
+// RouteKey limits requests by the http.ServeMux pattern that matched them, so
+// every route has its own limit. The middleware has to run inside the mux
+// for the pattern to be known.
+func RouteKey() KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		if r.Pattern == "" {
+			return "", false
+		}
+		return "route:" + r.Pattern, true
+	}
+}
+
+// CompositeKey limits requests by the combination of several keys, e.g. the
+// client IP per route. It fails if any of the keys fails.
+func CompositeKey(keyFuncs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		parts := make([]string, 0, len(keyFuncs))
+		for _, keyFunc := range keyFuncs {
+			part, ok := keyFunc(r)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, "|"), len(parts) > 0
+	}
+}
+
+// FirstKey limits requests by the first key that can be derived, e.g. the API
+// key if there is one and the client IP otherwise.
+func FirstKey(keyFuncs ...KeyFunc) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		for _, keyFunc := range keyFuncs {
+			if key, ok := keyFunc(r); ok {
+				return key, true
+			}
+		}
+		return "", false
+	}
+}
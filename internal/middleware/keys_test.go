@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+type userCtxKey struct{}
+
+type user struct {
+	name string
+}
+
+func (u user) String() string {
+	return u.name
+}
+
+func TestKeyFuncs(t *testing.T) {
+	newRequest := func(target string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		r.RemoteAddr = "203.0.113.7:52311"
+		return r
+	}
+
+	withHeader := newRequest("/")
+	withHeader.Header.Set("X-Api-Key", "secret|key")
+
+	withUser := newRequest("/")
+	withUser = withUser.WithContext(context.WithValue(withUser.Context(), userCtxKey{}, user{name: "alice"}))
+
+	withPattern := newRequest("/items/42")
+	withPattern.Pattern = "GET /items/{id}"
+
+	ipv6 := newRequest("/")
+	ipv6.RemoteAddr = "[2001:db8::1]:443"
+
+	tests := []struct {
+		name    string
+		keyFunc KeyFunc
+		r       *http.Request
+		want    string
+		wantOK  bool
+	}{
+		{"client ip", ClientIPKey(), newRequest("/"), "ip:203.0.113.7", true},
+		{"client ipv6", ClientIPKey(), ipv6, "ip:2001:db8::1", true},
+		{"ipv6 prefix", ClientIPKey(WithIPv6Prefix(64)), ipv6, "ip:2001:db8::/64", true},
+		{"ipv6 prefix leaves ipv4 alone", ClientIPKey(WithIPv6Prefix(56)), newRequest("/"), "ip:203.0.113.7", true},
+		{"ipv4 prefix", ClientIPKey(WithIPv4Prefix(24)), newRequest("/"), "ip:203.0.113.0/24", true},
+		{"ipv4 prefix leaves ipv6 alone", ClientIPKey(WithIPv4Prefix(24)), ipv6, "ip:2001:db8::1", true},
+		{"full length prefix", ClientIPKey(WithIPv4Prefix(32), WithIPv6Prefix(128)), ipv6, "ip:2001:db8::1", true},
+		{"header", HeaderKey("x-api-key"), withHeader, "header:X-Api-Key=secret%7Ckey", true},
+		{"missing header", HeaderKey("X-Api-Key"), newRequest("/"), "", false},
+		{"query", QueryKey("token"), newRequest("/?token=abc"), "query:token=abc", true},
+		{"missing query", QueryKey("token"), newRequest("/"), "", false},
+		{"context user", ContextKey(userCtxKey{}), withUser, "user:alice", true},
+		{"missing context user", ContextKey(userCtxKey{}), newRequest("/"), "", false},
+		{"route", RouteKey(), withPattern, "route:GET /items/{id}", true},
+		{"missing route", RouteKey(), newRequest("/"), "", false},
+		{"composite", CompositeKey(RouteKey(), ClientIPKey()), withPattern, "route:GET /items/{id}|ip:203.0.113.7", true},
+		{"composite missing part", CompositeKey(HeaderKey("X-Api-Key"), ClientIPKey()), newRequest("/"), "", false},
+		{"first", FirstKey(HeaderKey("X-Api-Key"), ClientIPKey()), withHeader, "header:X-Api-Key=secret%7Ckey", true},
+		{"first fallback", FirstKey(HeaderKey("X-Api-Key"), ClientIPKey()), newRequest("/"), "ip:203.0.113.7", true},
+		{"first none", FirstKey(HeaderKey("X-Api-Key"), QueryKey("token")), newRequest("/"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.keyFunc(tt.r)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("expected %q, %v, got %q, %v", tt.want, tt.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_KeyIgnoresPort(t *testing.T) {
+	l, _ := limiter.NewRateLimiter("fixed_window", map[string]any{
+		"window_duration": time.Hour,
+		"window_tokens":   1,
+		"window_size":     1,
+	})
+	t.Cleanup(func() { l.(io.Closer).Close() })
+	handler := NewRateLimiter(l).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// two connections from the same client
+	for i, addr := range []string{"203.0.113.7:50000", "203.0.113.7:50001"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		want := http.StatusOK
+		if i > 0 {
+			want = http.StatusTooManyRequests
+		}
+		if w.Code != want {
+			t.Errorf("request from %s: expected status %d, got %d", addr, want, w.Code)
+		}
+	}
+}
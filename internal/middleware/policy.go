@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// PolicyRouter applies different limits to different routes. Routes are
+// matched with http.ServeMux patterns, e.g. "POST /login", "GET /search" or
+// "/static/", with the same precedence rules: the most specific pattern wins.
+// Requests matching no pattern are limited by the default policy.
+//
+// A policy is a RateLimiter, with its own limiter, key, headers and rejection
+// handler. Several patterns can share a policy, and with it their limits.
+type PolicyRouter struct {
+	mux      *http.ServeMux
+	fallback *RateLimiter
+}
+
+// policyHandler marks the pattern a policy was registered under, a nil policy
+// exempts the pattern
+type policyHandler struct {
+	policy *RateLimiter
+}
+
+func (policyHandler) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// NewPolicyRouter creates a PolicyRouter limiting requests that match no
+// pattern by fallback. A nil fallback lets them through unlimited.
+func NewPolicyRouter(fallback *RateLimiter) *PolicyRouter {
+	return &PolicyRouter{
+		mux:      http.NewServeMux(),
+		fallback: fallback,
+	}
+}
+
+// Handle limits requests matching pattern by policy. Like http.ServeMux.Handle
+// it panics if the pattern is invalid or conflicts with a registered one.
+func (pr *PolicyRouter) Handle(pattern string, policy *RateLimiter) {
+	pr.mux.Handle(pattern, policyHandler{policy: policy})
+}
+
+// Exempt lets requests matching any of patterns through unlimited, e.g.
+// health checks or static assets.
+func (pr *PolicyRouter) Exempt(patterns ...string) {
+	for _, pattern := range patterns {
+		pr.mux.Handle(pattern, policyHandler{})
+	}
+}
+
+// Policy returns the policy limiting r, nil if r is exempt
+func (pr *PolicyRouter) Policy(r *http.Request) *RateLimiter {
+	policy, _ := pr.match(r)
+	return policy
+}
+
+// match returns the policy limiting r and the pattern it was registered
+// under, which is empty for the default policy
+func (pr *PolicyRouter) match(r *http.Request) (*RateLimiter, string) {
+	// redirects and 405s come back as other handlers, they get the default
+	h, pattern := pr.mux.Handler(r)
+	if h, ok := h.(policyHandler); ok {
+		return h.policy, pattern
+	}
+	return pr.fallback, ""
+}
+
+// Middleware limits every request by its policy. Unless the router runs
+// inside a mux that already matched the request, r.Pattern is set to the
+// policy's pattern, so RouteKey works within policies.
+func (pr *PolicyRouter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy, pattern := pr.match(r)
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Pattern == "" && pattern != "" {
+			matched := *r
+			matched.Pattern = pattern
+			r = &matched
+		}
+		policy.serve(w, r, next)
+	})
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicyRouter(t *testing.T) {
+	router := NewPolicyRouter(NewRateLimiter(newFixedWindow(t, 3)))
+	router.Handle("POST /login", NewRateLimiter(newFixedWindow(t, 1)))
+	router.Handle("GET /search", NewRateLimiter(newFixedWindow(t, 2)))
+	router.Handle("GET /users/{id}", NewRateLimiter(newFixedWindow(t, 1), WithKeyFunc(QueryKey("token"))))
+	router.Exempt("/static/", "GET /healthz")
+	handler := router.Middleware(okHandler)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		want   []int
+	}{
+		{"login", http.MethodPost, "/login", []int{200, 429}},
+		{"search", http.MethodGet, "/search", []int{200, 200, 429}},
+		{"wildcard shares the policy", http.MethodGet, "/users/1", []int{200}},
+		{"wildcard", http.MethodGet, "/users/2", []int{429}},
+		{"policy key", http.MethodGet, "/users/2?token=abc", []int{200, 429}},
+		{"static assets", http.MethodGet, "/static/app.js", []int{200, 200, 200, 200}},
+		{"health check", http.MethodGet, "/healthz", []int{200, 200, 200, 200}},
+		{"default", http.MethodGet, "/login", []int{200, 200, 200, 429}},
+		{"default is shared by unmatched routes", http.MethodDelete, "/healthz", []int{429}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, want := range tt.want {
+				r := httptest.NewRequest(tt.method, tt.target, nil)
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, r)
+				if w.Code != want {
+					t.Errorf("request %d: expected status %d, got %d", i, want, w.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyRouter_NoDefault(t *testing.T) {
+	router := NewPolicyRouter(nil)
+	router.Handle("/api/", NewRateLimiter(newFixedWindow(t, 1)))
+	handler := router.Middleware(okHandler)
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, w.Code)
+		}
+		if got := w.Header().Get("RateLimit"); got != "" {
+			t.Errorf("expected no rate limit headers, got %q", got)
+		}
+	}
+}
+
+func TestPolicyRouter_RouteKey(t *testing.T) {
+	// one login attempt per client and route
+	router := NewPolicyRouter(nil)
+	router.Handle("POST /login", NewRateLimiter(newFixedWindow(t, 1), WithKeyFunc(CompositeKey(ClientIPKey(), RouteKey()))))
+
+	var patterns []string
+	handler := router.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		patterns = append(patterns, r.Pattern)
+	}))
+
+	send := func(remoteAddr string) int {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for _, addr := range []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"} {
+		if code := send(addr); code != http.StatusOK {
+			t.Errorf("%s: expected every client to have its own limit, got %d", addr, code)
+		}
+	}
+	if code := send("192.0.2.1:1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the client's second attempt to be limited, got %d", code)
+	}
+	if len(patterns) == 0 || patterns[0] != "POST /login" {
+		t.Errorf("expected the handler to see the policy's pattern, got %v", patterns)
+	}
+}
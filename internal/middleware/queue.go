@@ -0,0 +1,214 @@
+package middleware
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// QueueConfig makes the middleware hold requests the limits deny until they
+// allow them, instead of rejecting them right away. It follows nginx's
+// limit_req: with a GCRA limiter of Burst 1 as the zone's rate,
+//
+//	limit_req zone=one burst=12 delay=8;
+//
+// becomes QueueConfig{Burst: 12, Delay: 8}, and nodelay becomes NoDelay.
+type QueueConfig struct {
+	// Burst is how many requests per key may be in excess of the limit at
+	// once; further ones are rejected. Requests are in excess from the time
+	// they are admitted until the limit would have allowed them.
+	Burst int
+	// Delay is how many of the excess requests are let through right away,
+	// the rest wait until the limit allows them.
+	Delay int
+	// NoDelay lets every excess request through right away, like Delay set
+	// to Burst.
+	NoDelay bool
+	// MaxDelay is the longest a request is held, those that would have to
+	// wait longer are rejected. Zero means no bound besides Burst.
+	MaxDelay time.Duration
+}
+
+// WithQueue holds requests the limits deny until they allow them, within the
+// bounds of cfg. A request whose client goes away while it waits gives its
+// capacity back and gets no response.
+//
+// Excess requests can only be let through right away by limiters that
+// reserve capacity ahead, the token bucket, GCRA and leaky bucket. The window
+// based limiters hold every excess request until its window opens.
+func WithQueue(cfg QueueConfig) Option {
+	return func(rl *RateLimiter) {
+		if cfg.NoDelay {
+			cfg.Delay = cfg.Burst
+		}
+		rl.queue = &requestQueue{
+			cfg:    cfg,
+			excess: make(map[string]int),
+		}
+	}
+}
+
+// requestQueue counts the excess requests of every key
+type requestQueue struct {
+	cfg    QueueConfig
+	mu     sync.Mutex
+	excess map[string]int
+
+	// testHookEnter is called after a request tried to enter the queue, so
+	// tests can send requests in a known order
+	testHookEnter func()
+}
+
+// enter admits an excess request for key and returns how many were ahead of
+// it. ok is false if the key's burst is used up.
+func (q *requestQueue) enter(key string) (ahead int, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.testHookEnter != nil {
+		defer q.testHookEnter()
+	}
+
+	ahead = q.excess[key]
+	if ahead >= q.cfg.Burst {
+		return ahead, false
+	}
+	q.excess[key] = ahead + 1
+	return ahead, true
+}
+
+// leave ends an excess request of key
+func (q *requestQueue) leave(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.excess[key] <= 1 {
+		delete(q.excess, key)
+		return
+	}
+	q.excess[key]--
+}
+
+// serveQueued works like serve, except that requests the limits deny are
+// held until they are allowed, within the bounds of the queue
+func (rl *RateLimiter) serveQueued(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	q := rl.queue
+	key := keyFor(rl.key, r)
+	var deadline time.Time
+	if q.cfg.MaxDelay > 0 {
+		deadline = time.Now().Add(q.cfg.MaxDelay)
+	}
+
+	entered := false
+	leave := func() {
+		if entered {
+			q.leave(key)
+		}
+	}
+
+	for {
+		decision, held, ok := rl.reserve(r)
+		wait := decision.RetryAfter
+
+		// a cost the limits can never allow
+		if !ok && wait <= 0 {
+			leave()
+			rl.rejectRequest(w, r, decision)
+			return
+		}
+
+		if wait == 0 {
+			leave()
+			rl.writeHeaders(w.Header(), decision, time.Now())
+			rl.forward(w, r, next, decision, held)
+			return
+		}
+
+		if !deadline.IsZero() && time.Now().Add(wait).After(deadline) {
+			giveBack(held)
+			leave()
+			rl.rejectRequest(w, r, decision)
+			return
+		}
+
+		if !entered {
+			ahead, admitted := q.enter(key)
+			if !admitted {
+				giveBack(held)
+				rl.rejectRequest(w, r, decision)
+				return
+			}
+			entered = true
+
+			// the request stays in excess until its reservation comes due
+			if ok && ahead < q.cfg.Delay {
+				time.AfterFunc(wait, func() { q.leave(key) })
+				rl.writeHeaders(w.Header(), decision, time.Now())
+				rl.forward(w, r, next, decision, held)
+				return
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			giveBack(held)
+			leave()
+			return
+		case <-timer.C:
+		}
+
+		if ok {
+			leave()
+			rl.writeHeaders(w.Header(), decision, time.Now())
+			rl.forward(w, r, next, decision, held)
+			return
+		}
+		// the window based limiters can't reserve ahead, try again now that
+		// the window has opened
+	}
+}
+
+// reserve reserves the request's cost with every limit, possibly in the
+// future, and returns the decision of the limit with the least room left.
+// Its RetryAfter is how long the request has to wait for every reservation
+// to come due. ok is false if a limit can't reserve the cost at all, the
+// decision is then that limit's and nothing is held.
+func (rl *RateLimiter) reserve(r *http.Request) (decision limiter.Decision, held []*limiter.Reservation, ok bool) {
+	cost := rl.cost(r)
+	held = make([]*limiter.Reservation, 0, len(rl.layers))
+
+	var delay time.Duration
+	for i, l := range rl.layers {
+		reservation := l.rlimiter.Reserve(keyFor(l.key, r), cost)
+		if !reservation.OK() {
+			giveBack(held)
+			return reservation.Decision(), nil, false
+		}
+		held = append(held, reservation)
+
+		delay = max(delay, reservation.Delay())
+		if d := reservation.Decision(); i == 0 || d.Remaining < decision.Remaining {
+			decision = d
+		}
+	}
+
+	decision.Allowed = delay == 0
+	decision.RetryAfter = delay
+	return decision, held, true
+}
+
+// giveBack gives back the capacity of reservations for a request that was
+// rejected or didn't count. Reservations still pending are cancelled, so
+// later reservations keep their slots, those that came due are refunded.
+func giveBack(reservations []*limiter.Reservation) {
+	for _, reservation := range reservations {
+		if reservation.Delay() > 0 {
+			reservation.Cancel()
+		} else {
+			reservation.Refund()
+		}
+	}
+}
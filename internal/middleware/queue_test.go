@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// queueInterval is how often newStrictRate lets a key's requests through
+const queueInterval = 100 * time.Millisecond
+
+// reservingLimiter reports the delay of every reservation made with a
+// limiter, once the reservation is made
+type reservingLimiter struct {
+	limiter.Limiter
+	reserved chan time.Duration // the delay of every reservation
+}
+
+func newReservingLimiter(l limiter.Limiter) *reservingLimiter {
+	return &reservingLimiter{Limiter: l, reserved: make(chan time.Duration, 100)}
+}
+
+func (l *reservingLimiter) Reserve(key string, n int) *limiter.Reservation {
+	r := l.Limiter.Reserve(key, n)
+	select {
+	case l.reserved <- r.Delay():
+	default:
+	}
+	return r
+}
+
+// newStrictRate returns a limiter allowing one request per key every
+// queueInterval, like an nginx limit_req zone of 10r/s
+func newStrictRate() *reservingLimiter {
+	return newReservingLimiter(limiter.NewGCRALimiter(bucket.NewInMemoryBucket[bucket.GCRABucketType](), limiter.GCRAConfig{
+		Rate:   10,
+		Period: time.Second,
+		Burst:  1,
+	}))
+}
+
+// queuedRequest is what became of a request sent by sendQueued
+type queuedRequest struct {
+	code      int
+	forwarded time.Time // when the handler got it, zero if it didn't
+	answered  int       // the order the requests were answered in, from 1
+}
+
+// sendQueued sends n requests through a RateLimiter using l. Every request is
+// only sent once the previous one was answered or tried to enter the queue,
+// so they line up in the order they were sent. It waits for all of them and
+// returns them with the time the first one was sent.
+func sendQueued(l limiter.Limiter, n int, opts ...Option) (time.Time, []queuedRequest) {
+	requests := make([]queuedRequest, n)
+	var mu sync.Mutex
+	answered := 0
+
+	rl := NewRateLimiter(l, opts...)
+	entered := make(chan struct{}, n)
+	rl.queue.testHookEnter = func() { entered <- struct{}{} }
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, _ := strconv.Atoi(r.Header.Get("X-Request"))
+		mu.Lock()
+		defer mu.Unlock()
+		requests[i].forwarded = time.Now()
+	}))
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		done := make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done)
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("X-Request", strconv.Itoa(i))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			mu.Lock()
+			defer mu.Unlock()
+			answered++
+			requests[i].code, requests[i].answered = w.Code, answered
+		}()
+
+		select {
+		case <-entered:
+		case <-done:
+			// a request let through right away entered the queue before
+			select {
+			case <-entered:
+			default:
+			}
+		}
+	}
+	wg.Wait()
+	return start, requests
+}
+
+func expectCodes(t *testing.T, requests []queuedRequest, want ...int) {
+	t.Helper()
+	for i := range want {
+		if requests[i].code != want[i] {
+			t.Errorf("request %d: expected status %d, got %d", i, want[i], requests[i].code)
+		}
+	}
+}
+
+func TestRateLimiter_QueueDelays(t *testing.T) {
+	start, requests := sendQueued(newStrictRate(), 4, WithQueue(QueueConfig{Burst: 2}))
+	expectCodes(t, requests, 200, 200, 200, 429)
+
+	// every excess request waits for its turn at the rate
+	for i := 1; i <= 2; i++ {
+		if waited := requests[i].forwarded.Sub(start); waited < time.Duration(i)*queueInterval {
+			t.Errorf("request %d: expected to wait for %d intervals, waited %v", i, i, waited)
+		}
+	}
+	if requests[1].answered > requests[2].answered {
+		t.Errorf("expected the excess requests to go through in order")
+	}
+	if requests[3].answered > requests[1].answered {
+		t.Errorf("expected the request beyond the burst to be rejected before the queue moved")
+	}
+}
+
+func TestRateLimiter_QueueNoDelay(t *testing.T) {
+	l := newStrictRate()
+	rl := NewRateLimiter(l, WithQueue(QueueConfig{Burst: 2, NoDelay: true}))
+	handler := rl.Middleware(okHandler)
+
+	// nothing waits, so the requests are answered one after another
+	start := time.Now()
+	for i, want := range []int{200, 200, 200, 429} {
+		if code := serve(handler, "192.0.2.1:1"); code != want {
+			t.Errorf("request %d: expected status %d, got %d", i, want, code)
+		}
+		if delay := <-l.reserved; i > 0 && delay <= 0 {
+			t.Errorf("request %d: expected to be in excess of the rate", i)
+		}
+	}
+
+	// the burst frees up at the rate
+	key := keyFor(rl.key, httptest.NewRequest(http.MethodGet, "/", nil))
+	for queued(rl, key) >= 2 {
+		time.Sleep(time.Millisecond)
+	}
+	if freed := time.Since(start); freed < queueInterval {
+		t.Errorf("expected a slot of the burst to be freed after an interval, freed after %v", freed)
+	}
+	if code := serve(handler, "192.0.2.1:1"); code != http.StatusOK {
+		t.Errorf("expected a slot of the burst to be free, got %d", code)
+	}
+}
+
+// queued returns how many excess requests of key rl's queue holds
+func queued(rl *RateLimiter, key string) int {
+	rl.queue.mu.Lock()
+	defer rl.queue.mu.Unlock()
+	return rl.queue.excess[key]
+}
+
+func TestRateLimiter_QueuePartialDelay(t *testing.T) {
+	start, requests := sendQueued(newStrictRate(), 4, WithQueue(QueueConfig{Burst: 3, Delay: 1}))
+	expectCodes(t, requests, 200, 200, 200, 200)
+
+	if requests[1].answered > requests[2].answered || requests[2].answered > requests[3].answered {
+		t.Errorf("expected the first excess request to go through right away, the others in order after it")
+	}
+	for i := 2; i <= 3; i++ {
+		if waited := requests[i].forwarded.Sub(start); waited < time.Duration(i)*queueInterval {
+			t.Errorf("request %d: expected to wait for %d intervals, waited %v", i, i, waited)
+		}
+	}
+}
+
+func TestRateLimiter_QueueMaxDelay(t *testing.T) {
+	// the second request waits for an interval, the third would wait for two
+	_, requests := sendQueued(newStrictRate(), 3, WithQueue(QueueConfig{Burst: 5, MaxDelay: queueInterval * 3 / 2}))
+	expectCodes(t, requests, 200, 200, 429)
+}
+
+func TestRateLimiter_QueueCancel(t *testing.T) {
+	l := newStrictRate()
+	handler := NewRateLimiter(l, WithQueue(QueueConfig{Burst: 5})).Middleware(okHandler)
+	serve(handler, "192.0.2.1:1")
+	<-l.reserved
+
+	// the client goes away while waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		handler.ServeHTTP(w, r)
+	}()
+	<-l.reserved
+	cancel()
+	<-served
+	if w.Code != http.StatusOK || w.Body.Len() > 0 {
+		t.Errorf("expected no response to the cancelled request, got %d", w.Code)
+	}
+
+	// its turn is given back, the next request only waits for the first
+	if code := serve(handler, "192.0.2.1:1"); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	if delay := <-l.reserved; delay > queueInterval {
+		t.Errorf("expected the cancelled request's turn to be given back, waited %v", delay)
+	}
+}
+
+func TestRateLimiter_QueueFixedWindow(t *testing.T) {
+	fw, _ := limiter.NewRateLimiter("fixed_window", map[string]any{
+		"window_duration": time.Second,
+		"window_tokens":   1,
+		"window_size":     1,
+	})
+	t.Cleanup(func() { fw.(io.Closer).Close() })
+
+	// start right after a window opens, so the requests arrive in one window
+	now := time.Now()
+	windowEnd := now.Truncate(time.Second).Add(2 * time.Second)
+	time.Sleep(windowEnd.Add(-time.Second).Sub(now))
+
+	_, requests := sendQueued(fw, 3, WithQueue(QueueConfig{Burst: 1}))
+	expectCodes(t, requests, 200, 200, 429)
+	if requests[1].forwarded.Before(windowEnd) {
+		t.Errorf("expected the excess request to wait for the next window, went through at %v", requests[1].forwarded)
+	}
+}
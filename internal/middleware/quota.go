@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// Quota gives a handler access to the limits of the request it serves, e.g.
+// to tell the client how much is left or to charge for work whose cost is
+// only known once it's done. It is safe for concurrent use.
+type Quota struct {
+	rl   *RateLimiter
+	keys []string // the request's key for every limit
+
+	mu       sync.Mutex
+	decision limiter.Decision
+}
+
+type quotaCtxKey struct{}
+
+// QuotaFromContext returns the Quota of the request ctx belongs to. ok is
+// false outside of the middleware and for requests on the allow list.
+func QuotaFromContext(ctx context.Context) (quota *Quota, ok bool) {
+	quota, ok = ctx.Value(quotaCtxKey{}).(*Quota)
+	return quota, ok
+}
+
+// Decision returns the state of the limit with the least room left, as of
+// the request being let through or the last Charge.
+func (q *Quota) Decision() limiter.Decision {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.decision
+}
+
+// Charge deducts n more tokens from every limit of the request, e.g. the
+// tokens a query produced or the milliseconds it computed, even if that puts
+// the key into debt. It returns the state of the limit with the least room
+// left afterwards. The rate limit headers keep reporting the state before the
+// handler ran.
+//
+// Charges are final, a response WithCountIf doesn't count only gives back the
+// request's upfront cost. All built-in limiters implement limiter.Charger;
+// other limiters are charged through Reserve instead, which charges nothing
+// if the key has fewer than n left.
+func (q *Quota) Charge(n int) limiter.Decision {
+	if n < 1 {
+		return q.Decision()
+	}
+
+	var tightest limiter.Decision
+	for i, l := range q.rl.layers {
+		var decision limiter.Decision
+		if charger, ok := l.rlimiter.(limiter.Charger); ok {
+			decision = charger.Charge(q.keys[i], n)
+		} else {
+			decision = l.rlimiter.Reserve(q.keys[i], n).Decision()
+		}
+		if i == 0 || decision.Remaining < tightest.Remaining {
+			tightest = decision
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.decision = tightest
+	return tightest
+}
+
+// withQuota returns r with a Quota holding decision in its context
+func (rl *RateLimiter) withQuota(r *http.Request, decision limiter.Decision) *http.Request {
+	keys := make([]string, len(rl.layers))
+	for i, l := range rl.layers {
+		keys[i] = keyFor(l.key, r)
+	}
+
+	quota := &Quota{
+		rl:       rl,
+		keys:     keys,
+		decision: decision,
+	}
+	return r.WithContext(context.WithValue(r.Context(), quotaCtxKey{}, quota))
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRateLimiter_QuotaCharge(t *testing.T) {
+	var remaining []int
+	search := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quota, ok := QuotaFromContext(r.Context())
+		if !ok {
+			t.Fatal("expected a quota in the request context")
+		}
+		remaining = append(remaining, quota.Decision().Remaining)
+
+		// the handler only knows the cost once it's done
+		cost, _ := strconv.Atoi(r.URL.Query().Get("cost"))
+		if decision := quota.Charge(cost); decision.Remaining != quota.Decision().Remaining {
+			t.Errorf("expected the quota to report the charge, got %+v", quota.Decision())
+		}
+	})
+	// 10 per client and 12 per /24
+	handler := NewRateLimiter(newFixedWindow(t, 10),
+		WithLayer(newFixedWindow(t, 12), ClientIPKey(WithIPv4Prefix(24))),
+	).Middleware(search)
+
+	send := func(remoteAddr string, cost int) int {
+		r := httptest.NewRequest(http.MethodGet, "/search?cost="+strconv.Itoa(cost), nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	send("192.0.2.1:1", 4)
+	send("192.0.2.1:1", 0)
+	if want := []int{9, 4}; len(remaining) != 2 || remaining[0] != want[0] || remaining[1] != want[1] {
+		t.Errorf("expected %v remaining, got %v", want, remaining)
+	}
+
+	// the charges count against every limit
+	if code := send("192.0.2.2:1", 20); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	for _, addr := range []string{"192.0.2.2:1", "192.0.2.3:1"} {
+		if code := send(addr, 0); code != http.StatusTooManyRequests {
+			t.Errorf("%s: expected the charge to put the key in debt, got %d", addr, code)
+		}
+	}
+}
+
+func TestRateLimiter_QuotaAllowList(t *testing.T) {
+	allow, _ := NewAccessList("192.0.2.0/24")
+	handler := NewRateLimiter(newFixedWindow(t, 1), WithAllowList(allow)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := QuotaFromContext(r.Context()); ok {
+			t.Error("expected no quota for an unlimited request")
+		}
+	}))
+	serve(handler, "192.0.2.1:1")
+}
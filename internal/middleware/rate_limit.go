@@ -2,27 +2,207 @@ package middleware
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
 )
 
 type RateLimiter struct {
 	rlimiter limiter.Limiter
+	cost     CostFunc
+	key      KeyFunc
+	layers   []layer // every limit applied, starting with rlimiter by key
+	headers  HeaderStyle
+	policy   string
+
+	reject        RejectFunc
+	rejectStatus  int
+	closeOnReject bool
+
+	allowList    *AccessList
+	denyList     *AccessList
+	listResolver *ClientIPResolver
+
+	queue   *requestQueue
+	countIf StatusPredicate
+}
+
+// layer is a further limit applied to every request, under its own key
+type layer struct {
+	rlimiter limiter.Limiter
+	key      KeyFunc
+}
+
+// CostFunc returns how many tokens a request consumes
+type CostFunc func(r *http.Request) int
+
+// Option configures a RateLimiter
+type Option func(rl *RateLimiter)
+
+// WithCost sets the function used to derive the cost of each request.
+// By default every request costs one token.
+func WithCost(cost CostFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.cost = cost
+	}
+}
+
+// WithKeyFunc sets the function used to derive the key each request is
+// limited under. By default requests are limited by client IP, see ClientIPKey.
+func WithKeyFunc(key KeyFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.key = key
+	}
 }
 
-func NewRateLimiter(rlimiter limiter.Limiter) *RateLimiter {
-	return &RateLimiter{
+// WithLayer adds a limit applied to every request on top of the middleware's
+// own, e.g. a limit per network prefix next to the one per address. A request
+// is only let through if every limit allows it; limits that already allowed
+// it give the tokens back if a later one denies it.
+func WithLayer(rlimiter limiter.Limiter, key KeyFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.layers = append(rl.layers, layer{rlimiter: rlimiter, key: key})
+	}
+}
+
+func NewRateLimiter(rlimiter limiter.Limiter, opts ...Option) *RateLimiter {
+	rl := &RateLimiter{
 		rlimiter: rlimiter,
+		cost:     func(r *http.Request) int { return 1 },
+		key:      ClientIPKey(),
+		headers:  HeadersIETF,
+		policy:   "default",
+
+		reject:       ProblemJSON,
+		rejectStatus: http.StatusTooManyRequests,
 	}
+
+	for _, opt := range opts {
+		opt(rl)
+	}
+	rl.layers = append([]layer{{rlimiter: rl.rlimiter, key: rl.key}}, rl.layers...)
+
+	return rl
 }
 
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// fmt.Printf("Rate limiter middleware %s", r.RemoteAddr)
-		if !rl.rlimiter.Allow(r.RemoteAddr) {
-			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		rl.serve(w, r, next)
+	})
+}
+
+// serve passes r on to next if the limits allow it and rejects it otherwise.
+// Clients on the deny list are always rejected, those on the allow list never.
+func (rl *RateLimiter) serve(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if rl.listed(rl.denyList, r) {
+		if rl.closeOnReject {
+			w.Header().Set("Connection", "close")
+		}
+		rl.reject(w, r, Rejection{Status: http.StatusForbidden, Blocked: true})
+		return
+	}
+	if rl.listed(rl.allowList, r) {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	if rl.queue != nil {
+		rl.serveQueued(w, r, next)
+		return
+	}
+
+	if rl.countIf != nil {
+		decision, held, _ := rl.reserve(r)
+		if !decision.Allowed {
+			giveBack(held)
+			rl.rejectRequest(w, r, decision)
 			return
 		}
+		rl.writeHeaders(w.Header(), decision, time.Now())
+		rl.forward(w, r, next, decision, held)
+		return
+	}
+
+	decision := rl.decide(r)
+	if !decision.Allowed {
+		rl.rejectRequest(w, r, decision)
+		return
+	}
+	rl.writeHeaders(w.Header(), decision, time.Now())
+	rl.forward(w, r, next, decision, nil)
+}
+
+// forward passes r on to next, with its Quota holding decision in the
+// context. With WithCountIf, the reservations held for r are given back if
+// the response doesn't count.
+func (rl *RateLimiter) forward(w http.ResponseWriter, r *http.Request, next http.Handler, decision limiter.Decision, held []*limiter.Reservation) {
+	// the request is let through, even if it had to wait for it
+	decision.Allowed, decision.RetryAfter = true, 0
+	r = rl.withQuota(r, decision)
+	if rl.countIf == nil {
 		next.ServeHTTP(w, r)
-	})
+		return
+	}
+
+	sw := &statusWriter{ResponseWriter: w}
+	next.ServeHTTP(sw, r)
+	if !rl.countIf(sw.Status()) {
+		giveBack(held)
+	}
+}
+
+// rejectRequest responds to a request denied by the limit that made decision
+func (rl *RateLimiter) rejectRequest(w http.ResponseWriter, r *http.Request, decision limiter.Decision) {
+	rl.writeHeaders(w.Header(), decision, time.Now())
+	writeRetryAfter(w.Header(), decision)
+	if rl.closeOnReject {
+		w.Header().Set("Connection", "close")
+	}
+	rl.reject(w, r, Rejection{Status: rl.rejectStatus, Decision: decision})
+}
+
+// decide checks r against every limit and returns the decision of the limit
+// that denied it or, if allowed, of the limit with the least room left. All
+// but the last limit reserve the request's tokens, so they can be given back
+// if a later limit denies it.
+func (rl *RateLimiter) decide(r *http.Request) limiter.Decision {
+	cost := rl.cost(r)
+	held := make([]*limiter.Reservation, 0, len(rl.layers)-1)
+
+	var tightest limiter.Decision
+	for i, l := range rl.layers {
+		key := keyFor(l.key, r)
+
+		var decision limiter.Decision
+		if i == len(rl.layers)-1 {
+			decision = l.rlimiter.DecideN(key, cost)
+		} else {
+			reservation := l.rlimiter.Reserve(key, cost)
+			decision = reservation.Decision()
+			if !reservation.OK() || reservation.Delay() > 0 {
+				reservation.Cancel()
+				decision.Allowed = false
+			} else {
+				held = append(held, reservation)
+			}
+		}
+
+		if !decision.Allowed {
+			giveBack(held)
+			return decision
+		}
+		if i == 0 || decision.Remaining < tightest.Remaining {
+			tightest = decision
+		}
+	}
+	return tightest
+}
+
+// keyFor returns the key r is limited under by keyFunc
+func keyFor(keyFunc KeyFunc, r *http.Request) string {
+	if key, ok := keyFunc(r); ok {
+		return key
+	}
+	return unknownKey
 }
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// newFixedWindow returns a limiter allowing tokens requests per key and hour
+func newFixedWindow(t *testing.T, tokens int) limiter.Limiter {
+	l, err := limiter.NewRateLimiter("fixed_window", map[string]any{
+		"window_duration": time.Hour,
+		"window_tokens":   tokens,
+		"window_size":     1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { l.(io.Closer).Close() })
+	return l
+}
+
+// serve sends a request from remoteAddr through handler and returns the status
+func serve(handler http.Handler, remoteAddr string) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestRateLimiter_Layers(t *testing.T) {
+	// 3 requests per address and 4 per /64
+	rl := NewRateLimiter(newFixedWindow(t, 3),
+		WithLayer(newFixedWindow(t, 4), ClientIPKey(WithIPv6Prefix(64))),
+	)
+	handler := rl.Middleware(okHandler)
+
+	// a client rotating through its /64
+	for i, addr := range []string{"[2001:db8::1]:1", "[2001:db8::2]:1", "[2001:db8::3]:1", "[2001:db8::4]:1"} {
+		if code := serve(handler, addr); code != http.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, code)
+		}
+	}
+	if code := serve(handler, "[2001:db8::5]:1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the /64 to be limited, got %d", code)
+	}
+
+	// another /64 is limited by address
+	for i := 0; i < 3; i++ {
+		if code := serve(handler, "[2001:db8:1::1]:1"); code != http.StatusOK {
+			t.Errorf("request %d: expected status 200, got %d", i, code)
+		}
+	}
+	if code := serve(handler, "[2001:db8:1::1]:1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected the address to be limited, got %d", code)
+	}
+}
+
+func TestRateLimiter_LayersGiveBackTokens(t *testing.T) {
+	// 2 requests per /24 checked first, then 1 per address
+	rl := NewRateLimiter(newFixedWindow(t, 2),
+		WithKeyFunc(ClientIPKey(WithIPv4Prefix(24))),
+		WithLayer(newFixedWindow(t, 1), ClientIPKey()),
+	)
+	handler := rl.Middleware(okHandler)
+
+	if code := serve(handler, "198.51.100.1:1"); code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", code)
+	}
+	// denied by the address limit, the /24 gets its token back
+	if code := serve(handler, "198.51.100.1:1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", code)
+	}
+	if code := serve(handler, "198.51.100.2:1"); code != http.StatusOK {
+		t.Errorf("expected the /24 to have a token left, got %d", code)
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// Rejection describes a request the middleware turned away
+type Rejection struct {
+	// Status is the status code to respond with, see WithRejectStatus
+	Status int
+	// Decision is the decision of the limit that denied the request, empty
+	// if the request was blocked
+	Decision limiter.Decision
+	// Blocked is set if the client is on the deny list, see WithDenyList
+	Blocked bool
+}
+
+// RejectFunc writes the response to a rejected request. The rate limit
+// headers and Retry-After are already set when it is called.
+type RejectFunc func(w http.ResponseWriter, r *http.Request, rejection Rejection)
+
+// WithRejectFunc sets the function writing the response to rejected requests.
+// The default is ProblemJSON.
+func WithRejectFunc(reject RejectFunc) Option {
+	return func(rl *RateLimiter) {
+		rl.reject = reject
+	}
+}
+
+// WithRejectStatus sets the status code rejected requests get, usually
+// http.StatusTooManyRequests, the default, or http.StatusServiceUnavailable
+// for limits protecting the service rather than metering clients.
+func WithRejectStatus(status int) Option {
+	return func(rl *RateLimiter) {
+		rl.rejectStatus = status
+	}
+}
+
+// WithCloseOnReject closes the connection after rejecting a request, so a
+// client hammering the service has to pay for a new connection every time.
+// It only applies to HTTP/1.x, HTTP/2 connections are shared by many requests.
+func WithCloseOnReject() Option {
+	return func(rl *RateLimiter) {
+		rl.closeOnReject = true
+	}
+}
+
+// problem is an RFC 9457 problem details object with the state of the limit
+type problem struct {
+	Type       string `json:"type"`
+	Title      string `json:"title"`
+	Status     int    `json:"status"`
+	Detail     string `json:"detail"`
+	Instance   string `json:"instance,omitempty"`
+	Limit      *int   `json:"limit,omitempty"`
+	Remaining  *int   `json:"remaining,omitempty"`
+	RetryAfter int64  `json:"retry_after,omitempty"` // in seconds
+}
+
+// ProblemJSON responds with an RFC 9457 application/problem+json object
+// holding the limit, the requests remaining and the seconds until a retry
+// can succeed, if it ever can. Blocked clients are only told so.
+func ProblemJSON(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+	decision := rejection.Decision
+	body := problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(rejection.Status),
+		Status:    rejection.Status,
+		Detail:    "The rate limit has been exceeded.",
+		Instance:  r.URL.Path,
+		Limit:     &decision.Limit,
+		Remaining: &decision.Remaining,
+	}
+	if rejection.Blocked {
+		body.Detail = "The client is blocked."
+		body.Limit, body.Remaining = nil, nil
+	} else if decision.RetryAfter > 0 {
+		body.RetryAfter = max(ceilSeconds(decision.RetryAfter), 1)
+		body.Detail = "The rate limit has been exceeded, retry after " + strconv.FormatInt(body.RetryAfter, 10) + " seconds."
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/problem+json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(rejection.Status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// PlainText responds with the status text, like http.Error
+func PlainText(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+	http.Error(w, http.StatusText(rejection.Status), rejection.Status)
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter_ProblemJSON(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(http.StatusText(status), func(t *testing.T) {
+			handler := NewRateLimiter(newFixedWindow(t, 1), WithRejectStatus(status)).Middleware(okHandler)
+			r := httptest.NewRequest(http.MethodGet, "/items", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != status {
+				t.Fatalf("expected status %d, got %d", status, w.Code)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("expected a problem+json response, got %q", got)
+			}
+
+			var body struct {
+				Type       string `json:"type"`
+				Status     int    `json:"status"`
+				Instance   string `json:"instance"`
+				Limit      int    `json:"limit"`
+				Remaining  int    `json:"remaining"`
+				RetryAfter int64  `json:"retry_after"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if body.Type != "about:blank" || body.Status != status || body.Instance != "/items" {
+				t.Errorf("unexpected problem %+v", body)
+			}
+			if body.Limit != 1 || body.Remaining != 0 {
+				t.Errorf("expected limit 1 and remaining 0, got %d and %d", body.Limit, body.Remaining)
+			}
+			if body.RetryAfter < 1 || body.RetryAfter > 3600 {
+				t.Errorf("expected retry_after within the window, got %d", body.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_RejectFunc(t *testing.T) {
+	var rejected Rejection
+	handler := NewRateLimiter(newFixedWindow(t, 1),
+		WithRejectFunc(func(w http.ResponseWriter, r *http.Request, rejection Rejection) {
+			rejected = rejection
+			PlainText(w, r, rejection)
+		}),
+		WithCloseOnReject(),
+	).Middleware(okHandler)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if got := w.Header().Get("Connection"); got != "" {
+		t.Errorf("expected the connection to stay open on success, got %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusTooManyRequests || rejected.Status != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", w.Code)
+	}
+	if rejected.Decision.Allowed || rejected.Decision.Limit != 1 {
+		t.Errorf("expected the denying decision, got %+v", rejected.Decision)
+	}
+	if got := w.Header().Get("Connection"); got != "close" {
+		t.Errorf("expected the connection to be closed, got %q", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+		t.Errorf("expected a plain text response, got %q", got)
+	}
+}
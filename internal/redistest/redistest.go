@@ -0,0 +1,529 @@
+// Package redistest provides an in-process stand-in for a Redis server, so
+// the stores and limiters that talk to Redis can be tested without one. It
+// speaks RESP, runs Lua scripts with an embedded Lua 5.1 interpreter, like
+// Redis does, and implements the commands the scripts and RedisBucket use.
+package redistest
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Server is a Redis stand-in listening on a local port. Commands, including
+// scripts, run one at a time like they do on Redis.
+type Server struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	data    map[string]*Entry
+	scripts map[string]*lua.FunctionProto
+	lua     *lua.LState
+	calls   map[string]int
+	now     func() time.Time
+}
+
+// Entry is a key held by the server, either a string or a hash
+type Entry struct {
+	Value     string
+	Hash      map[string]string // nil for a string
+	ExpiresAt time.Time         // zero if the key doesn't expire
+}
+
+// errorReply and statusReply are RESP error and simple string replies. Other
+// replies are nil, int64, string for bulk strings and []any for arrays.
+type errorReply string
+type statusReply string
+
+var errWrongType = errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// NewServer starts a server that is stopped when the test ends
+func NewServer(t testing.TB) *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	s := &Server{
+		listener: listener,
+		data:     make(map[string]*Entry),
+		scripts:  make(map[string]*lua.FunctionProto),
+		calls:    make(map[string]int),
+		now:      time.Now,
+	}
+	s.lua = s.newLua()
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.lua.Close()
+	})
+	return s
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// SetNow replaces the server's clock, which TIME reports and keys expire by
+func (s *Server) SetNow(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// Entry returns a copy of the key's entry, treating expired keys as missing
+func (s *Server) Entry(key string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry := s.lookup(key)
+	if entry == nil {
+		return Entry{}, false
+	}
+	copied := *entry
+	if entry.Hash != nil {
+		copied.Hash = make(map[string]string, len(entry.Hash))
+		for field, value := range entry.Hash {
+			copied.Hash[field] = value
+		}
+	}
+	return copied, true
+}
+
+// Calls returns how often clients sent the command, not counting the calls
+// made by scripts
+func (s *Server) Calls(command string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls[strings.ToUpper(command)]
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.calls[strings.ToUpper(args[0])]++
+		reply := s.exec(args, false)
+		s.mu.Unlock()
+
+		writeReply(w, reply)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a command, inScript telling whether a script called it
+func (s *Server) exec(args []string, inScript bool) any {
+	command := strings.ToUpper(args[0])
+	if arity, ok := arities[command]; !ok {
+		return errorReply(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+	} else if len(args) < arity {
+		return errorReply(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0])))
+	}
+
+	switch command {
+	case "GET":
+		entry := s.lookup(args[1])
+		if entry == nil {
+			return nil
+		}
+		if entry.Hash != nil {
+			return errWrongType
+		}
+		return entry.Value
+	case "SET":
+		entry := &Entry{Value: args[2]}
+		if len(args) > 3 {
+			if len(args) != 5 || strings.ToUpper(args[3]) != "PX" {
+				return errorReply("ERR syntax error")
+			}
+			ms, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || ms <= 0 {
+				return errorReply("ERR invalid expire time in 'set' command")
+			}
+			entry.ExpiresAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		s.data[args[1]] = entry
+		return statusReply("OK")
+	case "DEL":
+		deleted := int64(0)
+		for _, key := range args[1:] {
+			if s.lookup(key) != nil {
+				delete(s.data, key)
+				deleted++
+			}
+		}
+		return deleted
+	case "SCAN":
+		// only MATCH patterns ending in a single * are supported
+		if len(args) < 4 || strings.ToUpper(args[2]) != "MATCH" {
+			return errorReply("ERR syntax error")
+		}
+		prefix := strings.TrimSuffix(strings.ReplaceAll(args[3], "\\", ""), "*")
+		keys := []any{}
+		for key := range s.data {
+			if s.lookup(key) != nil && strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		return []any{"0", keys}
+	case "TIME":
+		now := s.now()
+		return []any{strconv.FormatInt(now.Unix(), 10), strconv.Itoa(now.Nanosecond() / 1000)}
+	case "HGET", "HMGET":
+		entry := s.lookup(args[1])
+		if entry != nil && entry.Hash == nil {
+			return errWrongType
+		}
+		values := make([]any, len(args)-2)
+		for i, field := range args[2:] {
+			if value, ok := entry.field(field); ok {
+				values[i] = value
+			}
+		}
+		if command == "HGET" {
+			return values[0]
+		}
+		return values
+	case "HSET":
+		if len(args)%2 != 0 {
+			return errorReply("ERR wrong number of arguments for 'hset' command")
+		}
+		entry := s.lookup(args[1])
+		if entry == nil {
+			entry = &Entry{Hash: make(map[string]string)}
+			s.data[args[1]] = entry
+		} else if entry.Hash == nil {
+			return errWrongType
+		}
+		added := int64(0)
+		for i := 2; i < len(args); i += 2 {
+			if _, ok := entry.Hash[args[i]]; !ok {
+				added++
+			}
+			entry.Hash[args[i]] = args[i+1]
+		}
+		return added
+	case "PEXPIRE":
+		ms, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return errorReply("ERR value is not an integer or out of range")
+		}
+		entry := s.lookup(args[1])
+		if entry == nil {
+			return int64(0)
+		}
+		if ms <= 0 {
+			delete(s.data, args[1])
+		} else {
+			entry.ExpiresAt = s.now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		return int64(1)
+	case "EVAL", "EVALSHA":
+		if inScript {
+			return errorReply("ERR This Redis command is not allowed from script")
+		}
+		return s.eval(command, args[1], args[2:])
+	}
+
+	return nil
+}
+
+// arities holds the minimum number of arguments of the supported commands,
+// the command included
+var arities = map[string]int{
+	"GET":     2,
+	"SET":     3,
+	"DEL":     2,
+	"SCAN":    2,
+	"TIME":    1,
+	"HGET":    3,
+	"HMGET":   3,
+	"HSET":    4,
+	"PEXPIRE": 3,
+	"EVAL":    3,
+	"EVALSHA": 3,
+}
+
+// lookup returns the key's entry, deleting it if it expired
+func (s *Server) lookup(key string) *Entry {
+	entry, ok := s.data[key]
+	if !ok {
+		return nil
+	}
+	if !entry.ExpiresAt.IsZero() && !entry.ExpiresAt.After(s.now()) {
+		delete(s.data, key)
+		return nil
+	}
+	return entry
+}
+
+func (e *Entry) field(name string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	value, ok := e.Hash[name]
+	return value, ok
+}
+
+// eval runs EVAL or EVALSHA with the script or SHA1 given as script
+func (s *Server) eval(command, script string, args []string) any {
+	numKeys, err := strconv.Atoi(args[0])
+	if err != nil || numKeys < 0 || numKeys > len(args)-1 {
+		return errorReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	sha := script
+	if command == "EVAL" {
+		sum := sha1.Sum([]byte(script))
+		sha = hex.EncodeToString(sum[:])
+		if _, ok := s.scripts[sha]; !ok {
+			chunk, err := parse.Parse(strings.NewReader(script), "@user_script")
+			if err != nil {
+				return errorReply("ERR Error compiling script: " + err.Error())
+			}
+			proto, err := lua.Compile(chunk, "@user_script")
+			if err != nil {
+				return errorReply("ERR Error compiling script: " + err.Error())
+			}
+			s.scripts[sha] = proto
+		}
+	}
+	proto, ok := s.scripts[strings.ToLower(sha)]
+	if !ok {
+		return errorReply("NOSCRIPT No matching script. Please use EVAL.")
+	}
+
+	L := s.lua
+	L.SetGlobal("KEYS", stringsTable(L, args[1:1+numKeys]))
+	L.SetGlobal("ARGV", stringsTable(L, args[1+numKeys:]))
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		var apiErr *lua.ApiError
+		if errors.As(err, &apiErr) {
+			return errorReply(fmt.Sprintf("ERR Error running script (call to f_%s): %s", sha, apiErr.Object.String()))
+		}
+		return errorReply("ERR " + err.Error())
+	}
+	result := L.Get(-1)
+	L.Pop(1)
+	return fromLua(result)
+}
+
+// newLua returns the interpreter scripts run in, with the redis library
+func (s *Server) newLua() *lua.LState {
+	L := lua.NewState()
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		reply := s.call(L)
+		if err, ok := reply.(errorReply); ok {
+			L.RaiseError("%s", string(err))
+		}
+		L.Push(toLua(L, reply))
+		return 1
+	}))
+	L.SetField(redis, "pcall", L.NewFunction(func(L *lua.LState) int {
+		L.Push(toLua(L, s.call(L)))
+		return 1
+	}))
+	L.SetField(redis, "error_reply", L.NewFunction(func(L *lua.LState) int {
+		reply := L.NewTable()
+		L.SetField(reply, "err", lua.LString(L.CheckString(1)))
+		L.Push(reply)
+		return 1
+	}))
+	L.SetField(redis, "status_reply", L.NewFunction(func(L *lua.LState) int {
+		reply := L.NewTable()
+		L.SetField(reply, "ok", lua.LString(L.CheckString(1)))
+		L.Push(reply)
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+	return L
+}
+
+// call runs the command a script passed to redis.call or redis.pcall.
+// Numbers are passed the way Redis formats them, with %.17g.
+func (s *Server) call(L *lua.LState) any {
+	args := make([]string, L.GetTop())
+	for i := range args {
+		switch v := L.Get(i + 1).(type) {
+		case lua.LString:
+			args[i] = string(v)
+		case lua.LNumber:
+			args[i] = strconv.FormatFloat(float64(v), 'g', 17, 64)
+		default:
+			return errorReply("ERR Lua redis lib command arguments must be strings or integers")
+		}
+	}
+	if len(args) == 0 {
+		return errorReply("ERR Please specify at least one argument for this redis lib call")
+	}
+	return s.exec(args, true)
+}
+
+func stringsTable(L *lua.LState, values []string) *lua.LTable {
+	table := L.CreateTable(len(values), 0)
+	for _, value := range values {
+		table.Append(lua.LString(value))
+	}
+	return table
+}
+
+// toLua converts a command's reply to a Lua value the way Redis does
+func toLua(L *lua.LState, reply any) lua.LValue {
+	switch v := reply.(type) {
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case statusReply:
+		table := L.NewTable()
+		L.SetField(table, "ok", lua.LString(v))
+		return table
+	case errorReply:
+		table := L.NewTable()
+		L.SetField(table, "err", lua.LString(v))
+		return table
+	case []any:
+		table := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			table.Append(toLua(L, item))
+		}
+		return table
+	}
+	return lua.LFalse
+}
+
+// fromLua converts a script's result to a reply the way Redis does: numbers
+// are truncated to integers, false is nil and arrays end at their first nil
+func fromLua(value lua.LValue) any {
+	switch v := value.(type) {
+	case lua.LNumber:
+		return int64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		if v {
+			return int64(1)
+		}
+		return nil
+	case *lua.LTable:
+		if err, ok := v.RawGetString("err").(lua.LString); ok {
+			return errorReply(err)
+		}
+		if status, ok := v.RawGetString("ok").(lua.LString); ok {
+			return statusReply(status)
+		}
+		var items []any
+		for i := 1; ; i++ {
+			item := v.RawGetInt(i)
+			if item == lua.LNil {
+				break
+			}
+			items = append(items, fromLua(item))
+		}
+		return items
+	}
+	return nil
+}
+
+// readCommand reads a command sent as an array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("Expected an array, got %q", line)
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 1 {
+		return nil, fmt.Errorf("Malformed array: %q", line)
+	}
+
+	args := make([]string, size)
+	for i := range args {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("Expected a bulk string, got %q", line)
+		}
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < 0 {
+			return nil, fmt.Errorf("Malformed bulk string: %q", line)
+		}
+		buf := make([]byte, length+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:length])
+	}
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case errorReply:
+		w.WriteString("-" + string(v) + "\r\n")
+	case statusReply:
+		w.WriteString("+" + string(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	}
+}
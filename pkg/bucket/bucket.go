@@ -8,27 +8,65 @@ type TokenBucketType struct {
 	Capacity   int       // total number of tokens
 	RefillRate float64   // tokens per second
 	Tokens     int       // number of tokens left
+	Initial    int       // number of tokens a new bucket starts with, Capacity if 0
 	LastRefill time.Time // last time the bucket was refilled
+	LastEvent  time.Time // time the latest reservation can be acted on
 }
 
 type FixedWindowBucketType struct {
-	CurrentWindow int64 // current window
-	WindowTokens  int   // number of tokens left in the current window
-	Capacity      int   // total number of tokens in the window
+	CurrentWindow int64     // current window
+	WindowTokens  int       // number of tokens left in the current window
+	Capacity      int       // total number of tokens in the window
+	WindowEnd     time.Time // time the current window ends
+}
+
+type LogEntry struct {
+	Timestamp time.Time // time the request was logged
+	Cost      int       // number of tokens the request consumed
 }
 
 type SlidingWindowLogBucketType struct {
-	WindowLog []time.Time
+	WindowLog []LogEntry
 	Capacity  int
+	Window    time.Duration // how long an entry stays in the log
+}
+
+type SlidingWindowCounterBucketType struct {
+	CurrentWindow int64         // current window
+	CurrentCount  int           // number of requests counted in the current window
+	PreviousCount int           // number of requests counted in the previous window
+	WindowLength  time.Duration // length of a window
 }
 
-type AllowedTypes interface {
-	TokenBucketType | FixedWindowBucketType | SlidingWindowLogBucketType
+type GCRABucketType struct {
+	TAT time.Time // theoretical arrival time of the next request
 }
 
+type LeakyBucketType struct {
+	LastRelease time.Time     // time the last queued request is released
+	Interval    time.Duration // time between two released requests
+}
+
+// AllowedTypes is the constraint on the state types a Bucket stores. Any type
+// is allowed, so algorithms registered outside this package can bring their
+// own state; the types above are the states of the built-in limiters.
+//
+// States implementing Expirer are reclaimed once they expired. Stores keeping
+// states outside the process encode them with a Codec, JSON by default, and
+// snapshots are always JSON, so a state's fields should be exported.
+type AllowedTypes interface{}
+
 type Bucket[T AllowedTypes] interface {
 	Get(key string) *T
 	Set(key string, bucket *T) error
+	Update(key string, fn UpdateFunc[T]) error
 	Delete(key string) error
 	Clear()
 }
+
+// UpdateFunc receives the current state of a key, nil if the key doesn't exist,
+// and returns the state to store in its place. Returning a nil state leaves the
+// key untouched and returning an error aborts the update.
+// Stores may call it more than once if the key changed underneath them, so it
+// shouldn't have side effects beyond the state it returns.
+type UpdateFunc[T AllowedTypes] func(current *T) (*T, error)
@@ -0,0 +1,40 @@
+package bucket
+
+import "encoding/json"
+
+// Codec turns states into bytes and back for stores that keep them outside
+// the process, like RedisBucket and SQLBucket. Custom state types only need a
+// codec of their own if JSONCodec can't round-trip them.
+type Codec interface {
+	Marshal(state any) ([]byte, error)
+	Unmarshal(data []byte, state any) error
+}
+
+// JSONCodec encodes states as JSON, it is the default codec of every store
+type JSONCodec struct{}
+
+func (JSONCodec) Marshal(state any) ([]byte, error) {
+	return json.Marshal(state)
+}
+
+func (JSONCodec) Unmarshal(data []byte, state any) error {
+	return json.Unmarshal(data, state)
+}
+
+// encodeState encodes state with codec
+func encodeState[T AllowedTypes](codec Codec, state *T) (string, error) {
+	data, err := codec.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decodeState decodes a state encoded by encodeState
+func decodeState[T AllowedTypes](codec Codec, raw string) (*T, error) {
+	state := new(T)
+	if err := codec.Unmarshal([]byte(raw), state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
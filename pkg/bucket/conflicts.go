@@ -0,0 +1,34 @@
+package bucket
+
+import (
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ErrTooManyConflicts is returned by Update when the key kept changing
+// underneath it, e.g. because many replicas update it at once.
+var ErrTooManyConflicts = errors.New("Too many conflicting updates")
+
+// how often stores shared between processes retry a conflicting update
+const maxConflictRetries = 50
+
+// upper bound of the random pause between two conflicting updates
+const maxConflictBackoff = 5 * time.Millisecond
+
+// keyLocks serializes updates of the same key within a process for stores
+// shared between processes, so only writers in other processes can conflict.
+type keyLocks [64]sync.Mutex
+
+// get returns the lock guarding key
+func (l *keyLocks) get(key string) *sync.Mutex {
+	return &l[keyHash(key)%uint32(len(l))]
+}
+
+// conflictBackoff pauses after the attempt-th conflicting update, for a random
+// and growing time so competing writers don't keep conflicting in lockstep.
+func conflictBackoff(attempt int) {
+	backoff := min(time.Duration(attempt+1)*100*time.Microsecond, maxConflictBackoff)
+	time.Sleep(rand.N(backoff))
+}
@@ -0,0 +1,97 @@
+package bucket
+
+import (
+	"math"
+	"time"
+)
+
+// Expirer is implemented by states that know when they stop carrying any
+// information, i.e. from when on a freshly created state would behave the same.
+// Stores use it to reclaim idle keys. A zero time means the state doesn't expire.
+type Expirer interface {
+	ExpiresAt() time.Time
+}
+
+// expiresAt returns when state expires, or the zero time if it doesn't
+// implement Expirer, with either a value or a pointer receiver.
+func expiresAt[T AllowedTypes](state *T) time.Time {
+	if expirer, ok := any(state).(Expirer); ok {
+		return expirer.ExpiresAt()
+	}
+	return time.Time{}
+}
+
+// isExpired returns whether state expired at now
+func isExpired[T AllowedTypes](state *T, now time.Time) bool {
+	expiry := expiresAt(state)
+	return !expiry.IsZero() && !expiry.After(now)
+}
+
+// ExpiresAt returns the time the bucket holds the tokens a new bucket starts
+// with again, or is full if it starts with more than fit.
+func (b TokenBucketType) ExpiresAt() time.Time {
+	if b.RefillRate <= 0 {
+		return time.Time{}
+	}
+
+	initial := b.Initial
+	if initial <= 0 || initial > b.Capacity {
+		initial = b.Capacity
+	}
+	missing := initial - b.Tokens
+	if missing <= 0 {
+		return b.LastRefill
+	}
+	return b.LastRefill.Add(time.Duration(math.Ceil(float64(missing) / b.RefillRate * float64(time.Second))))
+}
+
+// ExpiresAt returns the time the current window ends.
+func (b FixedWindowBucketType) ExpiresAt() time.Time {
+	return b.WindowEnd
+}
+
+// ExpiresAt returns the time the newest entry leaves the log.
+func (b SlidingWindowLogBucketType) ExpiresAt() time.Time {
+	if b.Window <= 0 {
+		return time.Time{}
+	}
+
+	expiry := time.Time{}
+	for _, entry := range b.WindowLog {
+		if end := entry.Timestamp.Add(b.Window); end.After(expiry) {
+			expiry = end
+		}
+	}
+	if expiry.IsZero() {
+		// an empty log has nothing left to expire
+		return time.Unix(0, 0)
+	}
+	return expiry
+}
+
+// ExpiresAt returns the time neither window counts towards the limit anymore:
+// the current window keeps counting until the end of the next one.
+func (b SlidingWindowCounterBucketType) ExpiresAt() time.Time {
+	if b.WindowLength <= 0 {
+		return time.Time{}
+	}
+
+	windowEnd := time.Unix(0, (b.CurrentWindow+1)*int64(b.WindowLength))
+	if b.CurrentCount > 0 {
+		return windowEnd.Add(b.WindowLength)
+	}
+	return windowEnd
+}
+
+// ExpiresAt returns the TAT, from then on the key has its full burst again.
+func (b GCRABucketType) ExpiresAt() time.Time {
+	return b.TAT
+}
+
+// ExpiresAt returns the time the queue has drained.
+func (b LeakyBucketType) ExpiresAt() time.Time {
+	if b.Interval <= 0 {
+		return time.Time{}
+	}
+	return b.LastRelease.Add(b.Interval)
+}
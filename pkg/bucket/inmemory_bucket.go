@@ -1,29 +1,221 @@
 package bucket
 
+import (
+	"sync"
+	"time"
+)
+
+// number of shards an InMemoryBucket spreads its keys over by default
+const defaultShards = 32
+
+// InMemoryBucket stores buckets in memory. Keys are spread over a number of
+// shards, each guarded by its own lock, so it is safe for concurrent use and
+// goroutines working on different keys rarely contend.
+//
+// States implementing Expirer are treated as gone once they expired: Get
+// doesn't return them and Update starts over from a nil state. Expired keys
+// are removed by Sweep, which a janitor goroutine can run periodically.
 type InMemoryBucket[T AllowedTypes] struct {
+	shards []*inMemoryShard[T]
+	now    func() time.Time
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type inMemoryShard[T AllowedTypes] struct {
+	mu      sync.RWMutex
 	buckets map[string]*T
 }
 
-func NewInMemoryBucket[T AllowedTypes]() *InMemoryBucket[T] {
-	return &InMemoryBucket[T]{
-		buckets: make(map[string]*T),
+type inMemoryConfig struct {
+	shards          int
+	janitorInterval time.Duration
+}
+
+// InMemoryOption configures an InMemoryBucket
+type InMemoryOption func(cfg *inMemoryConfig)
+
+// WithShards sets the number of shards the keys are spread over.
+func WithShards(shards int) InMemoryOption {
+	return func(cfg *inMemoryConfig) {
+		cfg.shards = shards
+	}
+}
+
+// WithJanitor starts a goroutine that removes expired keys every interval.
+// Call Close to stop it.
+func WithJanitor(interval time.Duration) InMemoryOption {
+	return func(cfg *inMemoryConfig) {
+		cfg.janitorInterval = interval
+	}
+}
+
+func NewInMemoryBucket[T AllowedTypes](opts ...InMemoryOption) *InMemoryBucket[T] {
+	cfg := inMemoryConfig{
+		shards: defaultShards,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.shards < 1 {
+		cfg.shards = 1
+	}
+
+	shards := make([]*inMemoryShard[T], cfg.shards)
+	for i := range shards {
+		shards[i] = &inMemoryShard[T]{
+			buckets: make(map[string]*T),
+		}
+	}
+
+	b := &InMemoryBucket[T]{
+		shards: shards,
+		now:    time.Now,
+	}
+
+	if cfg.janitorInterval > 0 {
+		b.stop = make(chan struct{})
+		b.done = make(chan struct{})
+		go b.janitor(cfg.janitorInterval)
 	}
+
+	return b
 }
 
 func (b *InMemoryBucket[T]) Get(key string) *T {
-	return b.buckets[key]
+	shard := b.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	state := shard.buckets[key]
+	if state != nil && isExpired(state, b.now()) {
+		return nil
+	}
+	return state
 }
 
 func (b *InMemoryBucket[T]) Set(key string, bucket *T) error {
-	b.buckets[key] = bucket
+	shard := b.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.buckets[key] = bucket
+	return nil
+}
+
+// Update applies fn to the key's state while holding the key's shard lock, so
+// the read-modify-write is atomic with respect to every other call on the key.
+// fn works on a copy; Get callers holding the previous state never see it change.
+func (b *InMemoryBucket[T]) Update(key string, fn UpdateFunc[T]) error {
+	shard := b.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	var current *T
+	if stored := shard.buckets[key]; stored != nil && !isExpired(stored, b.now()) {
+		copied := *stored
+		current = &copied
+	}
+
+	next, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	if next != nil {
+		shard.buckets[key] = next
+	}
 	return nil
 }
 
 func (b *InMemoryBucket[T]) Delete(key string) error {
-	delete(b.buckets, key)
+	shard := b.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	delete(shard.buckets, key)
 	return nil
 }
 
 func (b *InMemoryBucket[T]) Clear() {
-	b.buckets = make(map[string]*T)
+	for _, shard := range b.shards {
+		shard.mu.Lock()
+		shard.buckets = make(map[string]*T)
+		shard.mu.Unlock()
+	}
+}
+
+// Len returns the number of keys in the bucket
+func (b *InMemoryBucket[T]) Len() int {
+	n := 0
+	for _, shard := range b.shards {
+		shard.mu.RLock()
+		n += len(shard.buckets)
+		shard.mu.RUnlock()
+	}
+	return n
+}
+
+// Sweep removes every expired key and returns how many were removed.
+func (b *InMemoryBucket[T]) Sweep() int {
+	removed := 0
+	now := b.now()
+	for _, shard := range b.shards {
+		shard.mu.Lock()
+		for key, state := range shard.buckets {
+			if isExpired(state, now) {
+				delete(shard.buckets, key)
+				removed++
+			}
+		}
+		shard.mu.Unlock()
+	}
+	return removed
+}
+
+// Close stops the janitor goroutine, if one was started. It is safe to call
+// more than once.
+func (b *InMemoryBucket[T]) Close() error {
+	if b.stop == nil {
+		return nil
+	}
+
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return nil
+}
+
+func (b *InMemoryBucket[T]) janitor(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Sweep()
+		}
+	}
+}
+
+func (b *InMemoryBucket[T]) shard(key string) *inMemoryShard[T] {
+	return b.shards[keyHash(key)%uint32(len(b.shards))]
+}
+
+// keyHash returns the 32-bit FNV-1a hash of key, used to spread keys over shards
+func keyHash(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash
 }
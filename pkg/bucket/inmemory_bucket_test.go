@@ -0,0 +1,271 @@
+package bucket
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInMemoryBucket_GetSetDelete(t *testing.T) {
+	b := NewInMemoryBucket[TokenBucketType]()
+
+	if b.Get("missing") != nil {
+		t.Errorf("expected nil for a missing key")
+	}
+
+	tb := &TokenBucketType{Tokens: 3}
+	b.Set("key", tb)
+	if got := b.Get("key"); got != tb {
+		t.Errorf("expected Get to return the stored bucket, got %v", got)
+	}
+
+	b.Delete("key")
+	if b.Get("key") != nil {
+		t.Errorf("expected key to be deleted")
+	}
+}
+
+func TestInMemoryBucket_Clear(t *testing.T) {
+	b := NewInMemoryBucket[FixedWindowBucketType](WithShards(4))
+
+	for i := 0; i < 100; i++ {
+		b.Set(strconv.Itoa(i), &FixedWindowBucketType{})
+	}
+	if b.Len() != 100 {
+		t.Errorf("expected 100 keys, got %d", b.Len())
+	}
+
+	b.Clear()
+	if b.Len() != 0 {
+		t.Errorf("expected no keys after Clear, got %d", b.Len())
+	}
+}
+
+func TestInMemoryBucket_Concurrent(t *testing.T) {
+	b := NewInMemoryBucket[TokenBucketType]()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := strconv.Itoa(i % 50)
+				b.Set(key, &TokenBucketType{Tokens: g})
+				b.Get(key)
+				if i%100 == 0 {
+					b.Delete(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if b.Len() > 50 {
+		t.Errorf("expected at most 50 keys, got %d", b.Len())
+	}
+}
+
+func TestInMemoryBucket_Update(t *testing.T) {
+	b := NewInMemoryBucket[FixedWindowBucketType]()
+
+	// nil current state for a new key
+	err := b.Update("key", func(fw *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		if fw != nil {
+			t.Errorf("expected nil state for a new key")
+		}
+		return &FixedWindowBucketType{WindowTokens: 5}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the previous state handed out by Get is not modified
+	before := b.Get("key")
+	b.Update("key", func(fw *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		fw.WindowTokens--
+		return fw, nil
+	})
+	if before.WindowTokens != 5 {
+		t.Errorf("expected state returned by Get to be left alone, got %d", before.WindowTokens)
+	}
+	if got := b.Get("key").WindowTokens; got != 4 {
+		t.Errorf("expected WindowTokens=4 after update, got %d", got)
+	}
+
+	// an error aborts the update
+	errAbort := errors.New("abort")
+	err = b.Update("key", func(fw *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		fw.WindowTokens = 0
+		return fw, errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("expected the update error to be returned, got %v", err)
+	}
+	if got := b.Get("key").WindowTokens; got != 4 {
+		t.Errorf("expected aborted update not to be stored, got %d", got)
+	}
+}
+
+func TestInMemoryBucket_Update_Atomic(t *testing.T) {
+	b := NewInMemoryBucket[FixedWindowBucketType]()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				b.Update("counter", func(fw *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+					if fw == nil {
+						fw = &FixedWindowBucketType{}
+					}
+					fw.WindowTokens++
+					return fw, nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := b.Get("counter").WindowTokens; got != 16*500 {
+		t.Errorf("expected %d increments, got %d", 16*500, got)
+	}
+}
+
+func TestInMemoryBucket_Expiry(t *testing.T) {
+	b := NewInMemoryBucket[FixedWindowBucketType]()
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Set("idle", &FixedWindowBucketType{WindowEnd: now.Add(-time.Second)})
+	b.Set("active", &FixedWindowBucketType{WindowEnd: now.Add(time.Second)})
+	b.Set("forever", &FixedWindowBucketType{})
+
+	if b.Get("idle") != nil {
+		t.Errorf("expected Get to skip the expired key")
+	}
+	if b.Get("active") == nil || b.Get("forever") == nil {
+		t.Errorf("expected unexpired keys to be returned")
+	}
+
+	b.Update("idle", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		if current != nil {
+			t.Errorf("expected Update to start over from a nil state, got %v", current)
+		}
+		return nil, nil
+	})
+
+	if removed := b.Sweep(); removed != 1 {
+		t.Errorf("expected Sweep to remove 1 key, removed %d", removed)
+	}
+	if b.Len() != 2 {
+		t.Errorf("expected 2 keys left, got %d", b.Len())
+	}
+
+	now = now.Add(2 * time.Second)
+	if removed := b.Sweep(); removed != 1 {
+		t.Errorf("expected Sweep to remove the key whose window ended, removed %d", removed)
+	}
+	if b.Get("forever") == nil {
+		t.Errorf("expected a key without expiry to be kept")
+	}
+}
+
+func TestInMemoryBucket_ExpiryTokenBucket(t *testing.T) {
+	b := NewInMemoryBucket[TokenBucketType]()
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	// 2 tokens missing at 1 token per second, full again after 2 seconds
+	b.Set("key", &TokenBucketType{Capacity: 5, RefillRate: 1, Tokens: 3, LastRefill: now})
+
+	now = now.Add(time.Second)
+	if b.Sweep() != 0 {
+		t.Errorf("expected a refilling bucket to be kept")
+	}
+
+	now = now.Add(time.Second)
+	if b.Sweep() != 1 {
+		t.Errorf("expected a full bucket to be removed")
+	}
+}
+
+func TestInMemoryBucket_ExpiryTokenBucketBelowCapacity(t *testing.T) {
+	b := NewInMemoryBucket[TokenBucketType]()
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	// new buckets start with 3 tokens, 2 are missing at 1 token per second
+	b.Set("key", &TokenBucketType{Capacity: 5, RefillRate: 1, Tokens: 1, Initial: 3, LastRefill: now})
+
+	now = now.Add(time.Second)
+	if b.Sweep() != 0 {
+		t.Errorf("expected a bucket below its initial tokens to be kept")
+	}
+
+	now = now.Add(time.Second)
+	if b.Sweep() != 1 {
+		t.Errorf("expected a bucket back at its initial tokens to be removed")
+	}
+}
+
+func TestInMemoryBucket_Janitor(t *testing.T) {
+	b := NewInMemoryBucket[GCRABucketType](WithJanitor(5 * time.Millisecond))
+	defer b.Close()
+
+	b.Set("idle", &GCRABucketType{TAT: time.Now()})
+	b.Set("active", &GCRABucketType{TAT: time.Now().Add(time.Hour)})
+
+	deadline := time.Now().Add(time.Second)
+	for b.Len() > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if b.Len() != 1 {
+		t.Fatalf("expected the janitor to remove the idle key, %d keys left", b.Len())
+	}
+
+	b.Close()
+	b.Close()
+
+	b.Set("idle", &GCRABucketType{TAT: time.Now()})
+	time.Sleep(20 * time.Millisecond)
+	if b.Len() != 2 {
+		t.Errorf("expected no sweeps after Close, %d keys left", b.Len())
+	}
+}
+
+// quotaState is a state type defined outside the built-in ones, with a
+// pointer receiver ExpiresAt
+type quotaState struct {
+	Used  int
+	Until time.Time
+}
+
+func (q *quotaState) ExpiresAt() time.Time {
+	return q.Until
+}
+
+func TestInMemoryBucket_CustomState(t *testing.T) {
+	b := NewInMemoryBucket[quotaState]()
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Update("key", func(current *quotaState) (*quotaState, error) {
+		if current == nil {
+			current = &quotaState{Until: now.Add(time.Second)}
+		}
+		current.Used++
+		return current, nil
+	})
+	if got := b.Get("key"); got == nil || got.Used != 1 {
+		t.Errorf("expected the custom state to be stored, got %v", got)
+	}
+
+	now = now.Add(time.Second)
+	if b.Sweep() != 1 {
+		t.Errorf("expected the custom state to expire")
+	}
+}
@@ -0,0 +1,281 @@
+package bucket
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBucketFull is returned by an LRUBucket using RejectNew when a new key
+// doesn't fit anymore.
+var ErrBucketFull = errors.New("Bucket is full")
+
+// DefaultOverflowKey is the key overflowing keys share under SharedOverflow
+const DefaultOverflowKey = "__overflow__"
+
+// OverflowPolicy decides what an LRUBucket does with a new key once it holds
+// its maximum number of keys.
+type OverflowPolicy int
+
+const (
+	// EvictLRU makes room by removing the least recently used key
+	EvictLRU OverflowPolicy = iota
+	// RejectNew refuses new keys with ErrBucketFull, so limiters deny them
+	RejectNew
+	// SharedOverflow maps new keys onto a single catch-all state, so they
+	// share one limit until room frees up
+	SharedOverflow
+)
+
+// LRUBucket stores at most a fixed number of keys in memory. Every Get, Set
+// and Update marks the key as recently used; what happens once the bucket is
+// full is decided by its OverflowPolicy. Expired states (see Expirer) are
+// reclaimed before the policy kicks in.
+type LRUBucket[T AllowedTypes] struct {
+	mu          sync.Mutex
+	maxKeys     int
+	policy      OverflowPolicy
+	overflowKey string
+	entries     map[string]*list.Element
+	order       *list.List // front is the most recently used key
+	overflow    *T         // catch-all state used by SharedOverflow
+	now         func() time.Time
+
+	evictions atomic.Uint64
+	overflows atomic.Uint64
+}
+
+type lruEntry[T AllowedTypes] struct {
+	key   string
+	state *T
+}
+
+type lruConfig struct {
+	policy      OverflowPolicy
+	overflowKey string
+}
+
+// LRUOption configures an LRUBucket
+type LRUOption func(cfg *lruConfig)
+
+// WithOverflowPolicy sets what happens to new keys once the bucket is full.
+// The default is EvictLRU.
+func WithOverflowPolicy(policy OverflowPolicy) LRUOption {
+	return func(cfg *lruConfig) {
+		cfg.policy = policy
+	}
+}
+
+// WithOverflowKey sets the key of the catch-all state used by SharedOverflow.
+// The catch-all doesn't count towards the maximum number of keys.
+func WithOverflowKey(key string) LRUOption {
+	return func(cfg *lruConfig) {
+		cfg.overflowKey = key
+	}
+}
+
+// NewLRUBucket creates an LRUBucket holding at most maxKeys keys.
+// A maxKeys below 1 is treated as 1.
+func NewLRUBucket[T AllowedTypes](maxKeys int, opts ...LRUOption) *LRUBucket[T] {
+	cfg := lruConfig{
+		policy:      EvictLRU,
+		overflowKey: DefaultOverflowKey,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if maxKeys < 1 {
+		maxKeys = 1
+	}
+
+	return &LRUBucket[T]{
+		maxKeys:     maxKeys,
+		policy:      cfg.policy,
+		overflowKey: cfg.overflowKey,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		now:         time.Now,
+	}
+}
+
+func (b *LRUBucket[T]) Get(key string) *T {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elem, ok := b.entries[key]; ok {
+		entry := elem.Value.(*lruEntry[T])
+		if isExpired(entry.state, b.now()) {
+			return nil
+		}
+		b.order.MoveToFront(elem)
+		return entry.state
+	}
+
+	if b.routesToOverflow(key) {
+		return b.liveOverflow()
+	}
+	return nil
+}
+
+func (b *LRUBucket[T]) Set(key string, bucket *T) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.store(key, bucket)
+}
+
+// Update applies fn to the key's state while holding the bucket's lock. Under
+// RejectNew, fn isn't called for a new key that doesn't fit and ErrBucketFull
+// is returned instead. Under SharedOverflow, fn receives the catch-all state.
+func (b *LRUBucket[T]) Update(key string, fn UpdateFunc[T]) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var current *T
+	if elem, ok := b.entries[key]; ok {
+		b.order.MoveToFront(elem)
+		current = elem.Value.(*lruEntry[T]).state
+		if isExpired(current, b.now()) {
+			current = nil
+		}
+	} else if b.full() && b.policy == RejectNew {
+		b.overflows.Add(1)
+		return ErrBucketFull
+	} else if b.routesToOverflow(key) {
+		current = b.liveOverflow()
+	}
+
+	if current != nil {
+		copied := *current
+		current = &copied
+	}
+
+	next, err := fn(current)
+	if err != nil {
+		return err
+	}
+
+	if next != nil {
+		return b.store(key, next)
+	}
+	return nil
+}
+
+func (b *LRUBucket[T]) Delete(key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if key == b.overflowKey {
+		b.overflow = nil
+	}
+	if elem, ok := b.entries[key]; ok {
+		b.order.Remove(elem)
+		delete(b.entries, key)
+	}
+	return nil
+}
+
+func (b *LRUBucket[T]) Clear() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries = make(map[string]*list.Element)
+	b.order.Init()
+	b.overflow = nil
+}
+
+// Len returns the number of keys in the bucket, not counting the catch-all
+func (b *LRUBucket[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.order.Len()
+}
+
+// Evictions returns how many keys were evicted to make room for new ones.
+func (b *LRUBucket[T]) Evictions() uint64 {
+	return b.evictions.Load()
+}
+
+// Overflows returns how many times a new key was rejected or mapped onto the
+// catch-all state because the bucket was full.
+func (b *LRUBucket[T]) Overflows() uint64 {
+	return b.overflows.Load()
+}
+
+// store saves the key's state, making room for it according to the policy.
+// It must be called with the lock held.
+func (b *LRUBucket[T]) store(key string, state *T) error {
+	if elem, ok := b.entries[key]; ok {
+		elem.Value.(*lruEntry[T]).state = state
+		b.order.MoveToFront(elem)
+		return nil
+	}
+
+	if key == b.overflowKey {
+		b.overflow = state
+		return nil
+	}
+
+	if b.full() {
+		switch b.policy {
+		case RejectNew:
+			b.overflows.Add(1)
+			return ErrBucketFull
+		case SharedOverflow:
+			b.overflows.Add(1)
+			b.overflow = state
+			return nil
+		default:
+			b.removeOldest()
+			b.evictions.Add(1)
+		}
+	}
+
+	b.entries[key] = b.order.PushFront(&lruEntry[T]{key: key, state: state})
+	return nil
+}
+
+// full reports whether a new key needs room. It reclaims the least recently
+// used key first if it expired. It must be called with the lock held.
+func (b *LRUBucket[T]) full() bool {
+	if b.order.Len() < b.maxKeys {
+		return false
+	}
+
+	oldest := b.order.Back()
+	if oldest != nil && isExpired(oldest.Value.(*lruEntry[T]).state, b.now()) {
+		b.removeOldest()
+		return false
+	}
+	return true
+}
+
+// routesToOverflow reports whether a key without an entry of its own uses the
+// catch-all state. It must be called with the lock held.
+func (b *LRUBucket[T]) routesToOverflow(key string) bool {
+	if key == b.overflowKey {
+		return true
+	}
+	return b.policy == SharedOverflow && b.full()
+}
+
+// liveOverflow returns the catch-all state, nil if it doesn't exist or expired
+func (b *LRUBucket[T]) liveOverflow() *T {
+	if b.overflow == nil || isExpired(b.overflow, b.now()) {
+		return nil
+	}
+	return b.overflow
+}
+
+func (b *LRUBucket[T]) removeOldest() {
+	oldest := b.order.Back()
+	if oldest == nil {
+		return
+	}
+	b.order.Remove(oldest)
+	delete(b.entries, oldest.Value.(*lruEntry[T]).key)
+}
@@ -0,0 +1,137 @@
+package bucket
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUBucket_EvictLRU(t *testing.T) {
+	b := NewLRUBucket[TokenBucketType](2)
+
+	b.Set("a", &TokenBucketType{Tokens: 1})
+	b.Set("b", &TokenBucketType{Tokens: 2})
+	// touch a so b is the least recently used key
+	b.Get("a")
+	b.Set("c", &TokenBucketType{Tokens: 3})
+
+	if b.Get("b") != nil {
+		t.Errorf("expected b to be evicted")
+	}
+	if b.Get("a") == nil || b.Get("c") == nil {
+		t.Errorf("expected a and c to be kept")
+	}
+	if b.Len() != 2 {
+		t.Errorf("expected 2 keys, got %d", b.Len())
+	}
+	if b.Evictions() != 1 {
+		t.Errorf("expected 1 eviction, got %d", b.Evictions())
+	}
+}
+
+func TestLRUBucket_RejectNew(t *testing.T) {
+	b := NewLRUBucket[TokenBucketType](1, WithOverflowPolicy(RejectNew))
+
+	if err := b.Set("a", &TokenBucketType{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := b.Set("b", &TokenBucketType{}); !errors.Is(err, ErrBucketFull) {
+		t.Errorf("expected ErrBucketFull, got %v", err)
+	}
+
+	called := false
+	err := b.Update("b", func(current *TokenBucketType) (*TokenBucketType, error) {
+		called = true
+		return current, nil
+	})
+	if !errors.Is(err, ErrBucketFull) || called {
+		t.Errorf("expected Update to fail without calling fn, got %v", err)
+	}
+
+	// existing keys keep working
+	if err := b.Set("a", &TokenBucketType{Tokens: 1}); err != nil {
+		t.Errorf("unexpected error updating an existing key: %v", err)
+	}
+	if b.Overflows() != 2 || b.Evictions() != 0 {
+		t.Errorf("expected 2 overflows and no evictions, got %d and %d", b.Overflows(), b.Evictions())
+	}
+}
+
+func TestLRUBucket_SharedOverflow(t *testing.T) {
+	b := NewLRUBucket[FixedWindowBucketType](1, WithOverflowPolicy(SharedOverflow))
+
+	increment := func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		if current == nil {
+			current = &FixedWindowBucketType{}
+		}
+		current.WindowTokens++
+		return current, nil
+	}
+
+	b.Update("a", increment)
+	b.Update("b", increment)
+	b.Update("c", increment)
+
+	if got := b.Get("a"); got == nil || got.WindowTokens != 1 {
+		t.Errorf("expected a to keep its own state, got %v", got)
+	}
+	if got := b.Get("b"); got == nil || got.WindowTokens != 2 {
+		t.Errorf("expected b and c to share the catch-all state, got %v", got)
+	}
+	if got := b.Get(DefaultOverflowKey); got != b.Get("c") {
+		t.Errorf("expected the catch-all to be reachable by its key")
+	}
+	if b.Len() != 1 {
+		t.Errorf("expected the catch-all not to count as a key, got %d keys", b.Len())
+	}
+
+	b.Delete("a")
+	b.Update("b", increment)
+	if got := b.Get("b"); got == nil || got.WindowTokens != 1 {
+		t.Errorf("expected b to get its own state once there is room, got %v", got)
+	}
+}
+
+func TestLRUBucket_ReclaimsExpired(t *testing.T) {
+	b := NewLRUBucket[GCRABucketType](1, WithOverflowPolicy(RejectNew))
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Set("a", &GCRABucketType{TAT: now.Add(time.Second)})
+	now = now.Add(2 * time.Second)
+
+	if err := b.Set("b", &GCRABucketType{TAT: now.Add(time.Second)}); err != nil {
+		t.Errorf("expected the expired key to make room, got %v", err)
+	}
+	if b.Overflows() != 0 || b.Evictions() != 0 {
+		t.Errorf("expected reclaiming an expired key not to count, got %d overflows and %d evictions", b.Overflows(), b.Evictions())
+	}
+}
+
+func TestLRUBucket_Concurrent(t *testing.T) {
+	b := NewLRUBucket[TokenBucketType](10)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				b.Update(strconv.Itoa(i%50), func(current *TokenBucketType) (*TokenBucketType, error) {
+					if current == nil {
+						current = &TokenBucketType{}
+					}
+					current.Tokens++
+					return current, nil
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if b.Len() != 10 {
+		t.Errorf("expected the bucket to stay at 10 keys, got %d", b.Len())
+	}
+}
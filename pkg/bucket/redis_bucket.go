@@ -0,0 +1,472 @@
+package bucket
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// casScript stores ARGV[2] under KEYS[1] only if the key still holds ARGV[1],
+// an empty ARGV[1] standing for a missing key. ARGV[3] is the TTL in
+// milliseconds: 0 keeps the key forever and -1 deletes it instead, its state
+// being disposable already. It returns 1 if the key was written, 0 otherwise.
+var casScript = NewScript(`local current = redis.call('GET', KEYS[1])
+if current == false then current = '' end
+if current ~= ARGV[1] then return 0 end
+local ttl = tonumber(ARGV[3])
+if ttl < 0 then
+  redis.call('DEL', KEYS[1])
+elseif ttl > 0 then
+  redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+  redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1`)
+
+const (
+	defaultRedisKeyPrefix  = "ratelimit:"
+	defaultRedisPoolSize   = 8
+	defaultRedisMaxRetries = maxConflictRetries
+	defaultRedisTimeout    = 5 * time.Second
+)
+
+// RedisBucket stores buckets in Redis so several processes can share a limit.
+// It speaks RESP directly and doesn't need a Redis client library.
+//
+// The built-in limiters run their check-and-consume through RunScript: each
+// algorithm is a Lua script that reads the clock with Redis' TIME, so every
+// decision is atomic across all processes sharing the Redis server and they
+// all agree on the time. Only the durations the limiters report, like a
+// reservation's Delay, are added to the local clock. The scripts need Redis 5
+// or later.
+//
+// Update, used by algorithms without a script, reads the key, runs the
+// check-and-consume in Go and writes the result back through a compare-and-set
+// Lua script, which only succeeds if nobody changed the key in between;
+// otherwise the update is retried on the fresh state. Decisions are atomic as
+// well, but two limitations remain:
+//
+//   - The algorithm runs on each process' local clock, so processes whose
+//     clocks are skewed disagree about refills and window boundaries; keep
+//     them synchronized, e.g. with NTP.
+//   - A key updated by many processes at once keeps conflicting, and Update
+//     gives up with ErrTooManyConflicts after WithMaxRetries attempts, which
+//     limiters treat as a denial.
+//
+// Updates of the same key within a process are serialized, so only writers in
+// other processes can conflict.
+//
+// Keys are stored under a prefix and expire when their state does (see
+// Expirer). States that don't expire by themselves are kept for the TTL set
+// by WithTTL, or forever if none is set.
+type RedisBucket[T AllowedTypes] struct {
+	addr        string
+	password    string
+	db          int
+	prefix      string
+	ttl         time.Duration
+	maxRetries  int
+	dialTimeout time.Duration
+	codec       Codec
+	now         func() time.Time
+
+	idle   chan *respConn
+	mu     sync.Mutex
+	closed bool
+
+	locks keyLocks
+}
+
+type redisConfig struct {
+	password    string
+	db          int
+	prefix      string
+	ttl         time.Duration
+	poolSize    int
+	maxRetries  int
+	dialTimeout time.Duration
+	codec       Codec
+}
+
+// RedisOption configures a RedisBucket
+type RedisOption func(cfg *redisConfig)
+
+// WithKeyPrefix sets the prefix of every key stored in Redis. The default is
+// "ratelimit:".
+func WithKeyPrefix(prefix string) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.prefix = prefix
+	}
+}
+
+// WithTTL sets how long states that don't expire by themselves are kept.
+func WithTTL(ttl time.Duration) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.ttl = ttl
+	}
+}
+
+// WithPassword authenticates every connection with AUTH.
+func WithPassword(password string) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.password = password
+	}
+}
+
+// WithDB selects the database every connection uses.
+func WithDB(db int) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.db = db
+	}
+}
+
+// WithPoolSize sets how many idle connections are kept open.
+func WithPoolSize(size int) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.poolSize = size
+	}
+}
+
+// WithMaxRetries sets how often Update retries after a conflicting write
+// before giving up with ErrTooManyConflicts.
+func WithMaxRetries(retries int) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.maxRetries = retries
+	}
+}
+
+// WithDialTimeout sets the timeout for connecting to Redis.
+func WithDialTimeout(timeout time.Duration) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.dialTimeout = timeout
+	}
+}
+
+// WithRedisCodec sets how states are encoded in Redis. The default is JSONCodec.
+func WithRedisCodec(codec Codec) RedisOption {
+	return func(cfg *redisConfig) {
+		cfg.codec = codec
+	}
+}
+
+// NewRedisBucket creates a RedisBucket for the Redis server at addr.
+// Connections are opened lazily, so an unreachable server shows up as errors
+// from the bucket's methods, which limiters treat as a denial.
+func NewRedisBucket[T AllowedTypes](addr string, opts ...RedisOption) *RedisBucket[T] {
+	cfg := redisConfig{
+		prefix:      defaultRedisKeyPrefix,
+		poolSize:    defaultRedisPoolSize,
+		maxRetries:  defaultRedisMaxRetries,
+		dialTimeout: defaultRedisTimeout,
+		codec:       JSONCodec{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.poolSize < 1 {
+		cfg.poolSize = 1
+	}
+	if cfg.maxRetries < 1 {
+		cfg.maxRetries = 1
+	}
+
+	return &RedisBucket[T]{
+		addr:        addr,
+		password:    cfg.password,
+		db:          cfg.db,
+		prefix:      cfg.prefix,
+		ttl:         cfg.ttl,
+		maxRetries:  cfg.maxRetries,
+		dialTimeout: cfg.dialTimeout,
+		codec:       cfg.codec,
+		now:         time.Now,
+		idle:        make(chan *respConn, cfg.poolSize),
+	}
+}
+
+// Get returns the key's state, nil if it doesn't exist or Redis can't be reached
+func (b *RedisBucket[T]) Get(key string) *T {
+	raw, err := b.get(key)
+	if err != nil || raw == "" {
+		return nil
+	}
+
+	state, err := decodeState[T](b.codec, raw)
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+func (b *RedisBucket[T]) Set(key string, bucket *T) error {
+	raw, err := encodeState(b.codec, bucket)
+	if err != nil {
+		return err
+	}
+
+	args := []string{"SET", b.prefix + key, raw}
+	switch ttl := b.ttlFor(bucket); {
+	case ttl < 0:
+		args = []string{"DEL", b.prefix + key}
+	case ttl > 0:
+		args = append(args, "PX", strconv.FormatInt(ttl, 10))
+	}
+
+	_, err = b.do(args...)
+	return err
+}
+
+// Update applies fn to the key's state and stores the result with a
+// compare-and-set, retrying on the fresh state after a short random pause if
+// the key changed in between. fn may therefore be called more than once.
+func (b *RedisBucket[T]) Update(key string, fn UpdateFunc[T]) error {
+	lock := b.locks.get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i := 0; i < b.maxRetries; i++ {
+		raw, err := b.get(key)
+		if err != nil {
+			return err
+		}
+
+		var current *T
+		if raw != "" {
+			if current, err = decodeState[T](b.codec, raw); err != nil {
+				return err
+			}
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+
+		encoded, err := encodeState(b.codec, next)
+		if err != nil {
+			return err
+		}
+
+		ttl := strconv.FormatInt(b.ttlFor(next), 10)
+		reply, err := b.eval(casScript, []string{b.prefix + key}, raw, encoded, ttl)
+		if err != nil {
+			return err
+		}
+		if written, _ := reply.(int64); written == 1 {
+			return nil
+		}
+
+		conflictBackoff(i)
+	}
+
+	return ErrTooManyConflicts
+}
+
+func (b *RedisBucket[T]) Delete(key string) error {
+	_, err := b.do("DEL", b.prefix+key)
+	return err
+}
+
+// Clear deletes every key under the bucket's prefix
+func (b *RedisBucket[T]) Clear() {
+	cursor := "0"
+	for {
+		reply, err := b.do("SCAN", cursor, "MATCH", escapePattern(b.prefix)+"*", "COUNT", "100")
+		if err != nil {
+			return
+		}
+
+		items, ok := reply.([]any)
+		if !ok || len(items) != 2 {
+			return
+		}
+		cursor, _ = items[0].(string)
+		keys, _ := items[1].([]any)
+
+		if len(keys) > 0 {
+			args := []string{"DEL"}
+			for _, key := range keys {
+				if k, ok := key.(string); ok {
+					args = append(args, k)
+				}
+			}
+			if _, err := b.do(args...); err != nil {
+				return
+			}
+		}
+
+		if cursor == "0" || cursor == "" {
+			return
+		}
+	}
+}
+
+// Close closes the idle connections. The bucket can't be used afterwards.
+func (b *RedisBucket[T]) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	close(b.idle)
+
+	for conn := range b.idle {
+		conn.close()
+	}
+	return nil
+}
+
+// get returns the raw state stored under key, "" if it doesn't exist
+func (b *RedisBucket[T]) get(key string) (string, error) {
+	reply, err := b.do("GET", b.prefix+key)
+	if errors.Is(err, errNilReply) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	raw, _ := reply.(string)
+	return raw, nil
+}
+
+// ttlFor returns the TTL in milliseconds to store state with: 0 for no TTL and
+// -1 if the state expired already.
+func (b *RedisBucket[T]) ttlFor(state *T) int64 {
+	expiry := expiresAt(state)
+	if expiry.IsZero() {
+		return b.ttl.Milliseconds()
+	}
+
+	ttl := expiry.Sub(b.now())
+	if ttl <= 0 {
+		return -1
+	}
+	// round up so the key doesn't expire before its state does
+	return int64((ttl + time.Millisecond - 1) / time.Millisecond)
+}
+
+// RunScript runs script on the key under the bucket's prefix. The built-in
+// limiters keep the states they run scripts on in Redis hashes of their own,
+// which Get and Update don't read.
+func (b *RedisBucket[T]) RunScript(script *Script, key string, args ...string) ([]int64, error) {
+	reply, err := b.eval(script, []string{b.prefix + key}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("Unexpected script reply: %v", reply)
+	}
+	values := make([]int64, len(items))
+	for i, item := range items {
+		if values[i], ok = item.(int64); !ok {
+			return nil, fmt.Errorf("Unexpected script reply: %v", reply)
+		}
+	}
+	return values, nil
+}
+
+// eval runs script by its SHA1, loading it first if the server doesn't know it yet
+func (b *RedisBucket[T]) eval(script *Script, keys []string, args ...string) (any, error) {
+	cmd := append([]string{"EVALSHA", script.sha, strconv.Itoa(len(keys))}, keys...)
+	cmd = append(cmd, args...)
+
+	reply, err := b.do(cmd...)
+	var rerr respError
+	if errors.As(err, &rerr) && strings.HasPrefix(string(rerr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", script.src
+		return b.do(cmd...)
+	}
+	return reply, err
+}
+
+// do runs a command on a pooled connection
+func (b *RedisBucket[T]) do(args ...string) (any, error) {
+	conn, err := b.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(args...)
+	var rerr respError
+	if err != nil && !errors.As(err, &rerr) && !errors.Is(err, errNilReply) {
+		// the connection is broken, don't reuse it
+		conn.close()
+		return nil, err
+	}
+
+	b.release(conn)
+	return reply, err
+}
+
+// conn returns an idle connection or opens a new one
+func (b *RedisBucket[T]) conn() (*respConn, error) {
+	select {
+	case conn, ok := <-b.idle:
+		if ok {
+			return conn, nil
+		}
+		return nil, errors.New("Redis bucket is closed")
+	default:
+	}
+
+	conn, err := dialRESP(b.addr, b.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.password != "" {
+		if _, err := conn.do("AUTH", b.password); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	if b.db != 0 {
+		if _, err := conn.do("SELECT", strconv.Itoa(b.db)); err != nil {
+			conn.close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// release puts conn back in the pool, closing it if the pool is full or closed
+func (b *RedisBucket[T]) release(conn *respConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		conn.close()
+		return
+	}
+
+	select {
+	case b.idle <- conn:
+	default:
+		conn.close()
+	}
+}
+
+// escapePattern escapes the characters SCAN's MATCH treats as wildcards
+func escapePattern(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
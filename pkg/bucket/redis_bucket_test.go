@@ -0,0 +1,258 @@
+package bucket
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/internal/redistest"
+)
+
+func TestRedisBucket_GetSetDelete(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[TokenBucketType](f.Addr(), WithKeyPrefix("test:"))
+	defer b.Close()
+
+	if b.Get("missing") != nil {
+		t.Errorf("expected nil for a missing key")
+	}
+
+	state := &TokenBucketType{Capacity: 5, RefillRate: 1, Tokens: 3, LastRefill: time.Now()}
+	if err := b.Set("key", state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := b.Get("key"); got == nil || got.Tokens != 3 || got.Capacity != 5 {
+		t.Errorf("expected the stored state back, got %v", got)
+	}
+	if _, ok := f.Entry("test:key"); !ok {
+		t.Errorf("expected the key to be stored under its prefix")
+	}
+
+	if err := b.Delete("key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Get("key") != nil {
+		t.Errorf("expected key to be deleted")
+	}
+}
+
+func TestRedisBucket_TTL(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[GCRABucketType](f.Addr(), WithKeyPrefix("test:"))
+	defer b.Close()
+
+	b.Set("active", &GCRABucketType{TAT: time.Now().Add(time.Minute)})
+	entry, ok := f.Entry("test:active")
+	if !ok {
+		t.Fatalf("expected the key to be stored")
+	}
+	if ttl := time.Until(entry.ExpiresAt); ttl < 59*time.Second || ttl > time.Minute+time.Second {
+		t.Errorf("expected the key to expire with its state, expires in %v", ttl)
+	}
+
+	b.Set("idle", &GCRABucketType{TAT: time.Now().Add(-time.Second)})
+	if _, ok := f.Entry("test:idle"); ok {
+		t.Errorf("expected an expired state not to be stored")
+	}
+
+	// a token bucket without refill never expires by itself
+	tb := NewRedisBucket[TokenBucketType](f.Addr(), WithKeyPrefix("test:"), WithTTL(time.Hour))
+	defer tb.Close()
+	tb.Set("forever", &TokenBucketType{Capacity: 5, Tokens: 1})
+	entry, _ = f.Entry("test:forever")
+	if ttl := time.Until(entry.ExpiresAt); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Errorf("expected the default TTL, expires in %v", ttl)
+	}
+}
+
+func TestRedisBucket_Update(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[FixedWindowBucketType](f.Addr())
+	defer b.Close()
+
+	windowEnd := time.Now().Add(time.Minute)
+	err := b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		if current != nil {
+			t.Errorf("expected nil for a missing key, got %v", current)
+		}
+		return &FixedWindowBucketType{WindowTokens: 1, WindowEnd: windowEnd}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// nil leaves the key untouched
+	b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		return nil, nil
+	})
+	if got := b.Get("key"); got == nil || got.WindowTokens != 1 {
+		t.Errorf("expected the key to be untouched, got %v", got)
+	}
+
+	errAbort := errors.New("abort")
+	err = b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		return &FixedWindowBucketType{WindowTokens: 100}, errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("expected the update to be aborted, got %v", err)
+	}
+	if got := b.Get("key"); got == nil || got.WindowTokens != 1 {
+		t.Errorf("expected the aborted update not to be stored, got %v", got)
+	}
+}
+
+func TestRedisBucket_UpdateAcrossReplicas(t *testing.T) {
+	f := redistest.NewServer(t)
+	replicas := []*RedisBucket[FixedWindowBucketType]{
+		NewRedisBucket[FixedWindowBucketType](f.Addr()),
+		NewRedisBucket[FixedWindowBucketType](f.Addr()),
+	}
+	for _, b := range replicas {
+		defer b.Close()
+	}
+
+	windowEnd := time.Now().Add(time.Minute)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		for _, b := range replicas {
+			wg.Add(1)
+			go func(b *RedisBucket[FixedWindowBucketType]) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					err := b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+						if current == nil {
+							current = &FixedWindowBucketType{WindowEnd: windowEnd}
+						}
+						current.WindowTokens++
+						return current, nil
+					})
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}
+			}(b)
+		}
+	}
+	wg.Wait()
+
+	if got := replicas[0].Get("key"); got == nil || got.WindowTokens != 800 {
+		t.Errorf("expected 800 increments across replicas, got %v", got)
+	}
+}
+
+func TestRedisBucket_RunScript(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[GCRABucketType](f.Addr(), WithKeyPrefix("test:"))
+	defer b.Close()
+
+	double := NewScript(`redis.call('SET', KEYS[1], ARGV[1])
+return {tonumber(ARGV[1]) * 2}`)
+
+	// the first run loads the script, the second one runs it by its SHA1
+	for i := int64(1); i <= 2; i++ {
+		reply, err := b.RunScript(double, "key", strconv.FormatInt(i, 10))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(reply) != 1 || reply[0] != 2*i {
+			t.Errorf("expected [%d], got %v", 2*i, reply)
+		}
+	}
+	if entry, ok := f.Entry("test:key"); !ok || entry.Value != "2" {
+		t.Errorf("expected the script to run on the prefixed key, got %+v", entry)
+	}
+	if f.Calls("EVAL") != 1 || f.Calls("EVALSHA") != 2 {
+		t.Errorf("expected the script to be loaded once and run by its SHA1 after, got %d EVAL and %d EVALSHA", f.Calls("EVAL"), f.Calls("EVALSHA"))
+	}
+
+	failing := NewScript("return redis.error_reply('ERR failing')")
+	if _, err := b.RunScript(failing, "key"); err == nil || !strings.Contains(err.Error(), "failing") {
+		t.Errorf("expected the script's error, got %v", err)
+	}
+
+	text := NewScript("return {'text'}")
+	if _, err := b.RunScript(text, "key"); err == nil {
+		t.Errorf("expected an error for a reply that isn't made of integers")
+	}
+}
+
+func TestRedisBucket_Clear(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[TokenBucketType](f.Addr(), WithKeyPrefix("test:"))
+	defer b.Close()
+	other := NewRedisBucket[TokenBucketType](f.Addr(), WithKeyPrefix("other:"))
+	defer other.Close()
+
+	for i := 0; i < 10; i++ {
+		b.Set(strconv.Itoa(i), &TokenBucketType{Tokens: i})
+	}
+	other.Set("kept", &TokenBucketType{})
+
+	b.Clear()
+	for i := 0; i < 10; i++ {
+		if b.Get(strconv.Itoa(i)) != nil {
+			t.Errorf("expected key %d to be cleared", i)
+		}
+	}
+	if other.Get("kept") == nil {
+		t.Errorf("expected keys under another prefix to be kept")
+	}
+}
+
+func TestRedisBucket_Unreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	b := NewRedisBucket[TokenBucketType](addr, WithDialTimeout(100*time.Millisecond))
+	defer b.Close()
+
+	err = b.Update("key", func(current *TokenBucketType) (*TokenBucketType, error) {
+		return &TokenBucketType{}, nil
+	})
+	if err == nil {
+		t.Errorf("expected an error for an unreachable server")
+	}
+}
+
+// upperCodec wraps JSONCodec and upper cases its output, to tell it apart
+type upperCodec struct{}
+
+func (upperCodec) Marshal(state any) ([]byte, error) {
+	data, err := JSONCodec{}.Marshal(state)
+	return bytes.ToUpper(data), err
+}
+
+func (upperCodec) Unmarshal(data []byte, state any) error {
+	return JSONCodec{}.Unmarshal(data, state)
+}
+
+func TestRedisBucket_Codec(t *testing.T) {
+	f := redistest.NewServer(t)
+	b := NewRedisBucket[quotaState](f.Addr(), WithKeyPrefix("test:"), WithRedisCodec(upperCodec{}))
+	defer b.Close()
+
+	until := time.Now().Add(time.Minute).UTC()
+	b.Update("key", func(current *quotaState) (*quotaState, error) {
+		return &quotaState{Used: 3, Until: until}, nil
+	})
+
+	entry, _ := f.Entry("test:key")
+	if !strings.Contains(entry.Value, `"USED":3`) {
+		t.Errorf("expected the state to be encoded with the codec, got %q", entry.Value)
+	}
+	if time.Until(entry.ExpiresAt) < 59*time.Second {
+		t.Errorf("expected the custom state's expiry to set the TTL, expires in %v", time.Until(entry.ExpiresAt))
+	}
+	if got := b.Get("key"); got == nil || got.Used != 3 {
+		t.Errorf("expected the custom state back, got %v", got)
+	}
+}
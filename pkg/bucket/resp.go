@@ -0,0 +1,132 @@
+package bucket
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// respError is an error reply sent by the server
+type respError string
+
+func (e respError) Error() string {
+	return string(e)
+}
+
+// errNilReply is returned by respConn.do for a nil bulk string or array
+var errNilReply = errors.New("Nil reply")
+
+// respConn is a connection to a server speaking RESP, the Redis protocol.
+// It isn't safe for concurrent use.
+type respConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+func dialRESP(addr string, timeout time.Duration) (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &respConn{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}, nil
+}
+
+// do sends a command and reads its reply. Replies are returned as string for
+// simple and bulk strings, int64 for integers and []any for arrays; error
+// replies are returned as a respError.
+func (c *respConn) do(args ...string) (any, error) {
+	writeCommand(c.w, args)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return readReply(c.r)
+}
+
+func (c *respConn) close() error {
+	return c.conn.Close()
+}
+
+// writeCommand writes args as an array of bulk strings. Write errors are kept
+// by w and reported by its next Flush.
+func writeCommand(w *bufio.Writer, args []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("Empty RESP reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, respError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errNilReply
+		}
+
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return nil, errNilReply
+		}
+
+		items := make([]any, size)
+		for i := range items {
+			item, err := readReply(r)
+			if err != nil && !errors.Is(err, errNilReply) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("Unknown RESP reply: %q", line)
+}
+
+// readLine reads a line terminated by \r\n and returns it without the terminator
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("Malformed RESP line: %q", line)
+	}
+	return line[:len(line)-2], nil
+}
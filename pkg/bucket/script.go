@@ -0,0 +1,34 @@
+package bucket
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// Script is a Lua script run next to the data by a ScriptRunner. It is sent
+// by its SHA1 and only sent in full if the server doesn't know it yet.
+type Script struct {
+	src string
+	sha string
+}
+
+// NewScript returns the script with the given Lua source
+func NewScript(src string) *Script {
+	return &Script{src: src, sha: scriptSHA(src)}
+}
+
+// ScriptRunner is implemented by stores that can run a limiter's
+// check-and-consume as a script on their server, like RedisBucket. The
+// built-in limiters use it instead of Update if their bucket implements it,
+// so every decision is a single atomic step on the server, can't conflict
+// with other processes and is timed by the server's clock.
+type ScriptRunner interface {
+	// RunScript runs script on key, passed as KEYS[1], with args as ARGV.
+	// The script has to reply with an array of integers.
+	RunScript(script *Script, key string, args ...string) ([]int64, error)
+}
+
+func scriptSHA(script string) string {
+	sum := sha1.Sum([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
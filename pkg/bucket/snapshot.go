@@ -0,0 +1,133 @@
+package bucket
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format written by WriteSnapshot
+const SnapshotVersion = 1
+
+// ErrSnapshotVersion is returned when restoring a snapshot written in a
+// format this version doesn't understand.
+var ErrSnapshotVersion = errors.New("Unsupported snapshot version")
+
+// ErrSnapshotType is returned when restoring a snapshot of another state type
+// than the bucket's.
+var ErrSnapshotType = errors.New("Snapshot holds another state type")
+
+// snapshot is the file format of an InMemoryBucket snapshot
+type snapshot[T AllowedTypes] struct {
+	Version int           `json:"version"`
+	Type    string        `json:"type"`
+	TakenAt time.Time     `json:"taken_at"`
+	Entries map[string]*T `json:"entries"`
+}
+
+// WriteSnapshot writes every unexpired key of the bucket to w.
+func (b *InMemoryBucket[T]) WriteSnapshot(w io.Writer) error {
+	now := b.now()
+	snap := snapshot[T]{
+		Version: SnapshotVersion,
+		Type:    stateType[T](),
+		TakenAt: now,
+		Entries: make(map[string]*T),
+	}
+
+	for _, shard := range b.shards {
+		shard.mu.RLock()
+		for key, state := range shard.buckets {
+			if !isExpired(state, now) {
+				copied := *state
+				snap.Entries[key] = &copied
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// ReadSnapshot restores the keys of a snapshot written by WriteSnapshot,
+// replacing the bucket's state for those keys.
+//
+// States hold absolute times, so the refills and window rollovers that
+// happened while the snapshot sat on disk are accounted for on the key's next
+// use; states that expired in the meantime aren't restored. It returns the
+// number of keys restored.
+func (b *InMemoryBucket[T]) ReadSnapshot(r io.Reader) (int, error) {
+	var snap snapshot[T]
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, err
+	}
+
+	if snap.Version != SnapshotVersion {
+		return 0, fmt.Errorf("%w: %d", ErrSnapshotVersion, snap.Version)
+	}
+	if snap.Type != stateType[T]() {
+		return 0, fmt.Errorf("%w: %s", ErrSnapshotType, snap.Type)
+	}
+
+	restored := 0
+	now := b.now()
+	for key, state := range snap.Entries {
+		if state == nil || isExpired(state, now) {
+			continue
+		}
+		b.Set(key, state)
+		restored++
+	}
+	return restored, nil
+}
+
+// SaveSnapshot writes a snapshot of the bucket to the file at path. The file
+// is replaced atomically, so a crash while saving leaves the previous
+// snapshot intact.
+func (b *InMemoryBucket[T]) SaveSnapshot(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := b.WriteSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot restores the snapshot in the file at path, see ReadSnapshot.
+// A missing file restores nothing and isn't an error, so it can be called
+// unconditionally at startup.
+func (b *InMemoryBucket[T]) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return b.ReadSnapshot(f)
+}
+
+// stateType returns the name of the state type stored in a snapshot
+func stateType[T AllowedTypes]() string {
+	var state T
+	return fmt.Sprintf("%T", state)
+}
@@ -0,0 +1,121 @@
+package bucket
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshot_RoundTrip(t *testing.T) {
+	now := time.Now()
+	b := NewInMemoryBucket[SlidingWindowLogBucketType]()
+	b.Set("a", &SlidingWindowLogBucketType{
+		WindowLog: []LogEntry{{Timestamp: now, Cost: 2}},
+		Capacity:  5,
+		Window:    time.Minute,
+	})
+	b.Set("b", &SlidingWindowLogBucketType{
+		WindowLog: []LogEntry{{Timestamp: now.Add(-2 * time.Minute), Cost: 1}},
+		Capacity:  5,
+		Window:    time.Minute,
+	})
+
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewInMemoryBucket[SlidingWindowLogBucketType]()
+	n, err := restored.ReadSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected only the unexpired key to be restored, restored %d", n)
+	}
+
+	got := restored.Get("a")
+	if got == nil || len(got.WindowLog) != 1 || got.WindowLog[0].Cost != 2 || !got.WindowLog[0].Timestamp.Equal(now) {
+		t.Errorf("expected the log to be restored, got %v", got)
+	}
+	if restored.Get("b") != nil {
+		t.Errorf("expected the expired key not to be restored")
+	}
+}
+
+func TestSnapshot_DropsStatesExpiredDuringDowntime(t *testing.T) {
+	now := time.Now()
+	b := NewInMemoryBucket[TokenBucketType]()
+	// 4 tokens missing at 1 token per second
+	b.Set("refilled", &TokenBucketType{Capacity: 5, RefillRate: 1, Tokens: 1, LastRefill: now})
+	// 4 tokens missing at 1 token per hour
+	b.Set("empty", &TokenBucketType{Capacity: 5, RefillRate: 1.0 / 3600, Tokens: 1, LastRefill: now})
+
+	var buf bytes.Buffer
+	if err := b.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// restart a minute later
+	restored := NewInMemoryBucket[TokenBucketType]()
+	restored.now = func() time.Time { return now.Add(time.Minute) }
+	if _, err := restored.ReadSnapshot(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if restored.Get("refilled") != nil {
+		t.Errorf("expected a bucket that refilled during the downtime to be dropped")
+	}
+	if got := restored.Get("empty"); got == nil || got.Tokens != 1 || !got.LastRefill.Equal(now) {
+		t.Errorf("expected the slowly refilling bucket to keep its state, got %v", got)
+	}
+}
+
+func TestSnapshot_Mismatch(t *testing.T) {
+	b := NewInMemoryBucket[FixedWindowBucketType]()
+	b.Set("key", &FixedWindowBucketType{WindowEnd: time.Now().Add(time.Minute)})
+
+	var buf bytes.Buffer
+	b.WriteSnapshot(&buf)
+
+	other := NewInMemoryBucket[TokenBucketType]()
+	if _, err := other.ReadSnapshot(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrSnapshotType) {
+		t.Errorf("expected ErrSnapshotType, got %v", err)
+	}
+
+	future := strings.Replace(buf.String(), `"version":1`, `"version":2`, 1)
+	if _, err := b.ReadSnapshot(strings.NewReader(future)); !errors.Is(err, ErrSnapshotVersion) {
+		t.Errorf("expected ErrSnapshotVersion, got %v", err)
+	}
+}
+
+func TestSnapshot_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.snapshot")
+
+	b := NewInMemoryBucket[FixedWindowBucketType]()
+	if n, err := b.LoadSnapshot(path); err != nil || n != 0 {
+		t.Errorf("expected a missing snapshot to restore nothing, got %d and %v", n, err)
+	}
+
+	b.Set("key", &FixedWindowBucketType{WindowTokens: 3, WindowEnd: time.Now().Add(time.Minute)})
+	if err := b.SaveSnapshot(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	restored := NewInMemoryBucket[FixedWindowBucketType]()
+	if n, err := restored.LoadSnapshot(path); err != nil || n != 1 {
+		t.Fatalf("expected 1 key to be restored, got %d and %v", n, err)
+	}
+	if got := restored.Get("key"); got == nil || got.WindowTokens != 3 {
+		t.Errorf("expected the window to be restored, got %v", got)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files to be left behind, got %d files", len(entries))
+	}
+}
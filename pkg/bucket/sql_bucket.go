@@ -0,0 +1,327 @@
+package bucket
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Placeholder is the bind parameter style of a SQL database
+type Placeholder int
+
+const (
+	// QuestionPlaceholder is used by SQLite: ?
+	QuestionPlaceholder Placeholder = iota
+	// DollarPlaceholder is used by PostgreSQL: $1, $2, ...
+	DollarPlaceholder
+)
+
+const defaultSQLTable = "rate_limit_buckets"
+
+// SQLBucket stores buckets in a table through database/sql, for deployments
+// that have a SQL database but no Redis. Its statements use INSERT ... ON
+// CONFLICT, so it works with SQLite and PostgreSQL but not MySQL. The table
+// holds one row per key:
+//
+//	CREATE TABLE rate_limit_buckets (
+//		bucket_key TEXT PRIMARY KEY,
+//		state      TEXT NOT NULL,
+//		version    BIGINT NOT NULL,
+//		expires_at BIGINT NOT NULL
+//	)
+//
+// Update reads the row, runs the limiter's check-and-consume in Go and writes
+// the result with an UPDATE conditioned on the version it read (or an INSERT
+// that does nothing if the key was created meanwhile), retrying on the fresh
+// state if another process won the race. Updates of the same key within a
+// process are serialized, so only writers in other processes can conflict.
+//
+// expires_at holds the state's expiry in Unix nanoseconds (see Expirer), 0 if
+// it doesn't expire. Expired rows are ignored and removed by Purge, which can
+// be run periodically with WithPurgeInterval.
+type SQLBucket[T AllowedTypes] struct {
+	db      *sql.DB
+	queries sqlQueries
+	codec   Codec
+	now     func() time.Time
+	locks   keyLocks
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// sqlQueries holds the statements of an SQLBucket, built once for its table
+// and placeholder style
+type sqlQueries struct {
+	create, get, insert, update, upsert, delete, clear, purge string
+}
+
+type sqlConfig struct {
+	table         string
+	placeholder   Placeholder
+	purgeInterval time.Duration
+	codec         Codec
+}
+
+// SQLOption configures an SQLBucket
+type SQLOption func(cfg *sqlConfig)
+
+// WithTable sets the table the states are stored in. The default is
+// "rate_limit_buckets". The name is put into the statements as is, so it must
+// not come from untrusted input.
+func WithTable(table string) SQLOption {
+	return func(cfg *sqlConfig) {
+		cfg.table = table
+	}
+}
+
+// WithPlaceholder sets the database's bind parameter style. The default is
+// QuestionPlaceholder.
+func WithPlaceholder(placeholder Placeholder) SQLOption {
+	return func(cfg *sqlConfig) {
+		cfg.placeholder = placeholder
+	}
+}
+
+// WithPurgeInterval starts a goroutine that removes expired rows every
+// interval. Call Close to stop it.
+func WithPurgeInterval(interval time.Duration) SQLOption {
+	return func(cfg *sqlConfig) {
+		cfg.purgeInterval = interval
+	}
+}
+
+// WithSQLCodec sets how states are encoded in the state column. The default is
+// JSONCodec; the codec's output must be valid text unless the column is
+// changed to a binary type.
+func WithSQLCodec(codec Codec) SQLOption {
+	return func(cfg *sqlConfig) {
+		cfg.codec = codec
+	}
+}
+
+// NewSQLBucket creates an SQLBucket on top of db. Call CreateTable to create
+// its table if it doesn't exist yet.
+func NewSQLBucket[T AllowedTypes](db *sql.DB, opts ...SQLOption) *SQLBucket[T] {
+	cfg := sqlConfig{
+		table: defaultSQLTable,
+		codec: JSONCodec{},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	b := &SQLBucket[T]{
+		db:      db,
+		queries: newSQLQueries(cfg.table, cfg.placeholder),
+		codec:   cfg.codec,
+		now:     time.Now,
+	}
+
+	if cfg.purgeInterval > 0 {
+		b.stop = make(chan struct{})
+		b.done = make(chan struct{})
+		go b.purger(cfg.purgeInterval)
+	}
+
+	return b
+}
+
+func newSQLQueries(table string, placeholder Placeholder) sqlQueries {
+	// bind returns the query with its ? replaced by the placeholder style
+	bind := func(query string) string {
+		if placeholder != DollarPlaceholder {
+			return query
+		}
+
+		var sb strings.Builder
+		n := 0
+		for _, r := range query {
+			if r == '?' {
+				n++
+				sb.WriteString("$" + strconv.Itoa(n))
+				continue
+			}
+			sb.WriteRune(r)
+		}
+		return sb.String()
+	}
+
+	return sqlQueries{
+		create: "CREATE TABLE IF NOT EXISTS " + table +
+			" (bucket_key TEXT PRIMARY KEY, state TEXT NOT NULL, version BIGINT NOT NULL, expires_at BIGINT NOT NULL)",
+		get: bind("SELECT state, version, expires_at FROM " + table + " WHERE bucket_key = ?"),
+		insert: bind("INSERT INTO " + table + " (bucket_key, state, version, expires_at) VALUES (?, ?, 1, ?)" +
+			" ON CONFLICT (bucket_key) DO NOTHING"),
+		update: bind("UPDATE " + table + " SET state = ?, version = version + 1, expires_at = ?" +
+			" WHERE bucket_key = ? AND version = ?"),
+		upsert: bind("INSERT INTO " + table + " (bucket_key, state, version, expires_at) VALUES (?, ?, 1, ?)" +
+			" ON CONFLICT (bucket_key) DO UPDATE SET state = excluded.state, version = " + table + ".version + 1, expires_at = excluded.expires_at"),
+		delete: bind("DELETE FROM " + table + " WHERE bucket_key = ?"),
+		clear:  "DELETE FROM " + table,
+		purge:  bind("DELETE FROM " + table + " WHERE expires_at > 0 AND expires_at <= ?"),
+	}
+}
+
+// CreateTable creates the bucket's table if it doesn't exist
+func (b *SQLBucket[T]) CreateTable() error {
+	_, err := b.db.Exec(b.queries.create)
+	return err
+}
+
+// Get returns the key's state, nil if it doesn't exist, expired or the
+// database can't be reached
+func (b *SQLBucket[T]) Get(key string) *T {
+	state, _, err := b.get(key)
+	if err != nil {
+		return nil
+	}
+	return state
+}
+
+func (b *SQLBucket[T]) Set(key string, bucket *T) error {
+	raw, err := encodeState(b.codec, bucket)
+	if err != nil {
+		return err
+	}
+
+	_, err = b.db.Exec(b.queries.upsert, key, raw, b.expiry(bucket))
+	return err
+}
+
+// Update applies fn to the key's state and stores the result with a
+// conditional UPDATE, retrying on the fresh state after a short random pause
+// if another process changed the row in between. fn may therefore be called
+// more than once.
+func (b *SQLBucket[T]) Update(key string, fn UpdateFunc[T]) error {
+	lock := b.locks.get(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	for i := 0; i < maxConflictRetries; i++ {
+		current, version, err := b.get(key)
+		if err != nil {
+			return err
+		}
+
+		next, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if next == nil {
+			return nil
+		}
+
+		raw, err := encodeState(b.codec, next)
+		if err != nil {
+			return err
+		}
+
+		var result sql.Result
+		if version == 0 {
+			result, err = b.db.Exec(b.queries.insert, key, raw, b.expiry(next))
+		} else {
+			result, err = b.db.Exec(b.queries.update, raw, b.expiry(next), key, version)
+		}
+		if err != nil {
+			return err
+		}
+
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 1 {
+			return nil
+		}
+
+		conflictBackoff(i)
+	}
+
+	return ErrTooManyConflicts
+}
+
+func (b *SQLBucket[T]) Delete(key string) error {
+	_, err := b.db.Exec(b.queries.delete, key)
+	return err
+}
+
+func (b *SQLBucket[T]) Clear() {
+	b.db.Exec(b.queries.clear)
+}
+
+// Purge removes every expired row and returns how many were removed.
+func (b *SQLBucket[T]) Purge() (int64, error) {
+	result, err := b.db.Exec(b.queries.purge, b.now().UnixNano())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Close stops the purge goroutine, if one was started. It doesn't close the
+// database and is safe to call more than once.
+func (b *SQLBucket[T]) Close() error {
+	if b.stop == nil {
+		return nil
+	}
+
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return nil
+}
+
+func (b *SQLBucket[T]) purger(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.Purge()
+		}
+	}
+}
+
+// get returns the key's state and the version of its row. An expired state is
+// returned as nil with its row's version, so updating it replaces the row; a
+// missing row has version 0.
+func (b *SQLBucket[T]) get(key string) (*T, int64, error) {
+	var raw string
+	var version, expiresAt int64
+	err := b.db.QueryRow(b.queries.get, key).Scan(&raw, &version, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if expiresAt > 0 && expiresAt <= b.now().UnixNano() {
+		return nil, version, nil
+	}
+
+	state, err := decodeState[T](b.codec, raw)
+	if err != nil {
+		return nil, 0, err
+	}
+	return state, version, nil
+}
+
+// expiry returns the value of the expires_at column for state
+func (b *SQLBucket[T]) expiry(state *T) int64 {
+	expiry := expiresAt(state)
+	if expiry.IsZero() {
+		return 0
+	}
+	// an expiry at or before the epoch would read as "never"
+	return max(expiry.UnixNano(), 1)
+}
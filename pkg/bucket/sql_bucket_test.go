@@ -0,0 +1,384 @@
+package bucket
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQLDriver is a database/sql driver keeping one table in memory. It
+// understands the statements SQLBucket issues, told apart by their leading
+// keywords, so the tests don't need a real database.
+type fakeSQLDriver struct {
+	mu  sync.Mutex
+	dbs map[string]*fakeSQLDB
+}
+
+type fakeSQLDB struct {
+	mu      sync.Mutex
+	rows    map[string]fakeSQLRow
+	queries []string
+}
+
+type fakeSQLRow struct {
+	state     string
+	version   int64
+	expiresAt int64
+}
+
+var fakeDriver = &fakeSQLDriver{dbs: make(map[string]*fakeSQLDB)}
+
+func init() {
+	sql.Register("fakesql", fakeDriver)
+}
+
+// openFakeSQL opens a fresh fake database for the test
+func openFakeSQL(t *testing.T) (*sql.DB, *fakeSQLDB) {
+	fake := &fakeSQLDB{rows: make(map[string]fakeSQLRow)}
+
+	fakeDriver.mu.Lock()
+	fakeDriver.dbs[t.Name()] = fake
+	fakeDriver.mu.Unlock()
+
+	db, err := sql.Open("fakesql", t.Name())
+	if err != nil {
+		t.Fatalf("failed to open the fake database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, fake
+}
+
+func (d *fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	db, ok := d.dbs[name]
+	if !ok {
+		return nil, errors.New("unknown fake database " + name)
+	}
+	return &fakeSQLConn{db: db}, nil
+}
+
+type fakeSQLConn struct {
+	db *fakeSQLDB
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error { return nil }
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type fakeSQLStmt struct {
+	db    *fakeSQLDB
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error  { return nil }
+func (s *fakeSQLStmt) NumInput() int { return -1 }
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.queries = append(s.db.queries, s.query)
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT") && strings.HasSuffix(s.query, "DO NOTHING"):
+		key := args[0].(string)
+		if _, ok := s.db.rows[key]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		s.db.rows[key] = fakeSQLRow{state: args[1].(string), version: 1, expiresAt: args[2].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		key := args[0].(string)
+		row := s.db.rows[key]
+		s.db.rows[key] = fakeSQLRow{state: args[1].(string), version: row.version + 1, expiresAt: args[2].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "UPDATE"):
+		key := args[2].(string)
+		row, ok := s.db.rows[key]
+		if !ok || row.version != args[3].(int64) {
+			return driver.RowsAffected(0), nil
+		}
+		s.db.rows[key] = fakeSQLRow{state: args[0].(string), version: row.version + 1, expiresAt: args[1].(int64)}
+		return driver.RowsAffected(1), nil
+	case strings.Contains(s.query, "WHERE expires_at"):
+		now := args[0].(int64)
+		removed := int64(0)
+		for key, row := range s.db.rows {
+			if row.expiresAt > 0 && row.expiresAt <= now {
+				delete(s.db.rows, key)
+				removed++
+			}
+		}
+		return driver.RowsAffected(removed), nil
+	case strings.HasPrefix(s.query, "DELETE") && strings.Contains(s.query, "WHERE"):
+		if _, ok := s.db.rows[args[0].(string)]; !ok {
+			return driver.RowsAffected(0), nil
+		}
+		delete(s.db.rows, args[0].(string))
+		return driver.RowsAffected(1), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		removed := int64(len(s.db.rows))
+		s.db.rows = make(map[string]fakeSQLRow)
+		return driver.RowsAffected(removed), nil
+	}
+
+	return nil, errors.New("unexpected statement: " + s.query)
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	s.db.queries = append(s.db.queries, s.query)
+
+	if !strings.HasPrefix(s.query, "SELECT") {
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+
+	rows := &fakeSQLRows{}
+	if row, ok := s.db.rows[args[0].(string)]; ok {
+		rows.values = [][]driver.Value{{row.state, row.version, row.expiresAt}}
+	}
+	return rows, nil
+}
+
+type fakeSQLRows struct {
+	values [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	return []string{"state", "version", "expires_at"}
+}
+
+func (r *fakeSQLRows) Close() error { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// bump changes the key's row as if another process updated it
+func (db *fakeSQLDB) bump(key string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	row := db.rows[key]
+	row.version++
+	db.rows[key] = row
+}
+
+func (db *fakeSQLDB) len() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return len(db.rows)
+}
+
+func TestSQLBucket_GetSetDelete(t *testing.T) {
+	db, _ := openFakeSQL(t)
+	b := NewSQLBucket[TokenBucketType](db)
+	if err := b.CreateTable(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if b.Get("missing") != nil {
+		t.Errorf("expected nil for a missing key")
+	}
+
+	state := &TokenBucketType{Capacity: 5, RefillRate: 1, Tokens: 3, LastRefill: time.Now()}
+	if err := b.Set("key", state); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := b.Get("key"); got == nil || got.Tokens != 3 || got.Capacity != 5 {
+		t.Errorf("expected the stored state back, got %v", got)
+	}
+
+	if err := b.Delete("key"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.Get("key") != nil {
+		t.Errorf("expected key to be deleted")
+	}
+}
+
+func TestSQLBucket_Update(t *testing.T) {
+	db, fake := openFakeSQL(t)
+	b := NewSQLBucket[FixedWindowBucketType](db)
+
+	windowEnd := time.Now().Add(time.Minute)
+	increment := func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		if current == nil {
+			current = &FixedWindowBucketType{WindowEnd: windowEnd}
+		}
+		current.WindowTokens++
+		return current, nil
+	}
+
+	if err := b.Update("key", increment); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// another process updates the row between our read and write
+	calls := 0
+	err := b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		calls++
+		if calls == 1 {
+			fake.bump("key")
+		}
+		return increment(current)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the conflicting update to be retried once, fn was called %d times", calls)
+	}
+	if got := b.Get("key"); got == nil || got.WindowTokens != 2 {
+		t.Errorf("expected 2 increments, got %v", got)
+	}
+
+	errAbort := errors.New("abort")
+	err = b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+		return &FixedWindowBucketType{WindowTokens: 100}, errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Errorf("expected the update to be aborted, got %v", err)
+	}
+	if got := b.Get("key"); got == nil || got.WindowTokens != 2 {
+		t.Errorf("expected the aborted update not to be stored, got %v", got)
+	}
+}
+
+func TestSQLBucket_UpdateAcrossReplicas(t *testing.T) {
+	db, _ := openFakeSQL(t)
+	replicas := []*SQLBucket[FixedWindowBucketType]{
+		NewSQLBucket[FixedWindowBucketType](db),
+		NewSQLBucket[FixedWindowBucketType](db),
+	}
+
+	windowEnd := time.Now().Add(time.Minute)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		for _, b := range replicas {
+			wg.Add(1)
+			go func(b *SQLBucket[FixedWindowBucketType]) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					err := b.Update("key", func(current *FixedWindowBucketType) (*FixedWindowBucketType, error) {
+						if current == nil {
+							current = &FixedWindowBucketType{WindowEnd: windowEnd}
+						}
+						current.WindowTokens++
+						return current, nil
+					})
+					if err != nil {
+						t.Errorf("unexpected error: %v", err)
+					}
+				}
+			}(b)
+		}
+	}
+	wg.Wait()
+
+	if got := replicas[0].Get("key"); got == nil || got.WindowTokens != 800 {
+		t.Errorf("expected 800 increments across replicas, got %v", got)
+	}
+}
+
+func TestSQLBucket_Expiry(t *testing.T) {
+	db, fake := openFakeSQL(t)
+	b := NewSQLBucket[GCRABucketType](db)
+	now := time.Now()
+	b.now = func() time.Time { return now }
+
+	b.Set("idle", &GCRABucketType{TAT: now.Add(-time.Second)})
+	b.Set("active", &GCRABucketType{TAT: now.Add(time.Second)})
+
+	if b.Get("idle") != nil {
+		t.Errorf("expected Get to skip the expired row")
+	}
+
+	// updating an expired row starts over from a nil state
+	err := b.Update("idle", func(current *GCRABucketType) (*GCRABucketType, error) {
+		if current != nil {
+			t.Errorf("expected a nil state for an expired row, got %v", current)
+		}
+		return &GCRABucketType{TAT: now.Add(time.Minute)}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now = now.Add(2 * time.Second)
+	removed, err := b.Purge()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if removed != 1 || fake.len() != 1 {
+		t.Errorf("expected Purge to remove the expired row, removed %d and %d rows left", removed, fake.len())
+	}
+	if b.Get("idle") == nil {
+		t.Errorf("expected the updated row to be kept")
+	}
+}
+
+func TestSQLBucket_PurgeInterval(t *testing.T) {
+	db, fake := openFakeSQL(t)
+	b := NewSQLBucket[GCRABucketType](db, WithPurgeInterval(5*time.Millisecond))
+	defer b.Close()
+
+	b.Set("idle", &GCRABucketType{TAT: time.Now()})
+	b.Set("active", &GCRABucketType{TAT: time.Now().Add(time.Hour)})
+
+	deadline := time.Now().Add(time.Second)
+	for fake.len() > 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if fake.len() != 1 {
+		t.Errorf("expected the expired row to be purged, %d rows left", fake.len())
+	}
+
+	b.Close()
+	b.Close()
+}
+
+func TestSQLBucket_DollarPlaceholders(t *testing.T) {
+	db, fake := openFakeSQL(t)
+	b := NewSQLBucket[TokenBucketType](db, WithPlaceholder(DollarPlaceholder), WithTable("limits"))
+
+	b.Update("key", func(current *TokenBucketType) (*TokenBucketType, error) {
+		return &TokenBucketType{Capacity: 1}, nil
+	})
+	b.Clear()
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	for _, query := range fake.queries {
+		if strings.Contains(query, "?") {
+			t.Errorf("expected $n placeholders, got %q", query)
+		}
+		if !strings.Contains(query, "limits") {
+			t.Errorf("expected the configured table, got %q", query)
+		}
+	}
+	if !strings.Contains(fake.queries[0], "bucket_key = $1") {
+		t.Errorf("expected numbered placeholders, got %q", fake.queries[0])
+	}
+}
@@ -0,0 +1,66 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLimiters_Charge charges a key past its limit and checks every limiter
+// keeps the debt, denying the key until it's paid off.
+func TestLimiters_Charge(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  map[string]any
+	}{
+		{"token_bucket", map[string]any{"capacity": 10, "refill_rate": 0.0001, "tokens": 10}},
+		{"fixed_window", map[string]any{"window_duration": time.Hour, "window_tokens": 10, "window_size": 1}},
+		{"sliding_window_log", map[string]any{"window_duration": time.Hour, "capacity": 10, "window_size": int64(1)}},
+		{"sliding_window_counter", map[string]any{"window_duration": time.Hour, "window_tokens": 10, "window_size": 1}},
+		{"gcra", map[string]any{"rate": 10, "period": time.Hour, "burst": 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRegistryLimiter(t, tt.name, tt.cfg)
+			charger, ok := l.(Charger)
+			if !ok {
+				t.Fatalf("expected %s to implement Charger", tt.name)
+			}
+
+			decision := charger.Charge("key", 4)
+			if !decision.Allowed || decision.Remaining != 6 || decision.Limit != 10 {
+				t.Errorf("expected 6 of 10 remaining, got %+v", decision)
+			}
+
+			// more than is left, and more than the limit altogether
+			decision = charger.Charge("key", 12)
+			if decision.Allowed || decision.Remaining != 0 || decision.RetryAfter <= 0 {
+				t.Errorf("expected the key to be in debt, got %+v", decision)
+			}
+			if l.Allow("key") {
+				t.Error("expected the key to be denied while in debt")
+			}
+			if d := l.Decide("key"); d.RetryAfter <= 0 || d.Remaining != 0 {
+				t.Errorf("expected the denial to say when to retry and nothing left, got %+v", d)
+			}
+
+			if !l.Allow("other") {
+				t.Error("expected other keys not to be charged")
+			}
+		})
+	}
+}
+
+func TestLeakyBucketLimiter_Charge(t *testing.T) {
+	l := newRegistryLimiter(t, "leaky_bucket", map[string]any{"leak_rate": 10.0, "queue_size": 5})
+	charger := l.(Charger)
+
+	// 3 intervals of 100ms
+	decision := charger.Charge("key", 3)
+	if decision.Allowed || decision.RetryAfter < 250*time.Millisecond || decision.RetryAfter > 300*time.Millisecond {
+		t.Errorf("expected the queue to be busy for 3 intervals, got %+v", decision)
+	}
+	if r := l.Reserve("key", 1); r.Delay() < 250*time.Millisecond {
+		t.Errorf("expected the next request to wait for the charged intervals, waits %v", r.Delay())
+	}
+}
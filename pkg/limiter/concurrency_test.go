@@ -0,0 +1,76 @@
+package limiter
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestLimiters_ConcurrentAllow hammers a single key from many goroutines and
+// checks that no limiter ever lets more requests through than its limit.
+// Run with -race to also catch unsynchronized access to the key's state.
+func TestLimiters_ConcurrentAllow(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   map[string]any
+		limit int
+	}{
+		{
+			name:  "token_bucket",
+			cfg:   map[string]any{"capacity": 20, "refill_rate": 0.0001, "tokens": 20},
+			limit: 20,
+		},
+		{
+			name:  "fixed_window",
+			cfg:   map[string]any{"window_duration": time.Hour, "window_tokens": 20, "window_size": 1},
+			limit: 20,
+		},
+		{
+			name:  "sliding_window_log",
+			cfg:   map[string]any{"window_duration": time.Hour, "capacity": 20, "window_size": int64(1)},
+			limit: 20,
+		},
+		{
+			name:  "sliding_window_counter",
+			cfg:   map[string]any{"window_duration": time.Hour, "window_tokens": 20, "window_size": 1},
+			limit: 20,
+		},
+		{
+			name:  "gcra",
+			cfg:   map[string]any{"rate": 20, "period": time.Hour, "burst": 20},
+			limit: 20,
+		},
+		{
+			name:  "leaky_bucket",
+			cfg:   map[string]any{"leak_rate": 0.0001, "queue_size": 5},
+			limit: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRegistryLimiter(t, tt.name, tt.cfg)
+
+			var allowed atomic.Int64
+			var wg sync.WaitGroup
+			for g := 0; g < 32; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 50; i++ {
+						if limiter.Allow("hammered") {
+							allowed.Add(1)
+						}
+						limiter.Decide("other")
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := allowed.Load(); got != int64(tt.limit) {
+				t.Errorf("expected exactly %d requests to be allowed, got %d", tt.limit, got)
+			}
+		})
+	}
+}
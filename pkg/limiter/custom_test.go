@@ -0,0 +1,104 @@
+package limiter_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+	"github.com/Myspheet/go-rate-limiter/pkg/limiter"
+)
+
+// quotaState is the state of quotaLimiter, a type pkg/bucket knows nothing about
+type quotaState struct {
+	Used  int
+	Until time.Time
+}
+
+func (q quotaState) ExpiresAt() time.Time {
+	return q.Until
+}
+
+// quotaLimiter allows a fixed number of requests per period, starting with
+// the key's first request. It's built with the exported API only, like an
+// algorithm registered outside the limiter package.
+type quotaLimiter struct {
+	bucket bucket.Bucket[quotaState]
+	quota  int
+	period time.Duration
+}
+
+func init() {
+	limiter.RegisterLimiter("test_quota", func(cfg map[string]any) limiter.Limiter {
+		return &quotaLimiter{
+			bucket: bucket.NewInMemoryBucket[quotaState](),
+			quota:  cfg["quota"].(int),
+			period: time.Hour,
+		}
+	})
+}
+
+func (q *quotaLimiter) Allow(key string) bool              { return q.DecideN(key, 1).Allowed }
+func (q *quotaLimiter) AllowN(key string, n int) bool      { return q.DecideN(key, n).Allowed }
+func (q *quotaLimiter) Decide(key string) limiter.Decision { return q.DecideN(key, 1) }
+func (q *quotaLimiter) DecideN(key string, n int) limiter.Decision {
+	return q.Reserve(key, n).Decision()
+}
+func (q *quotaLimiter) Wait(ctx context.Context, key string) error {
+	return limiter.WaitN(ctx, q, key, 1)
+}
+
+func (q *quotaLimiter) Reserve(key string, n int) *limiter.Reservation {
+	now := time.Now()
+	var r *limiter.Reservation
+	err := q.bucket.Update(key, func(state *quotaState) (*quotaState, error) {
+		if state == nil {
+			state = &quotaState{Until: now.Add(q.period)}
+		}
+
+		decision := limiter.Decision{Limit: q.quota, Remaining: q.quota - state.Used, ResetAt: state.Until}
+		if state.Used+n > q.quota {
+			decision.RetryAfter = state.Until.Sub(now)
+			r = limiter.DeniedReservation(decision)
+			return nil, nil
+		}
+
+		state.Used += n
+		decision.Allowed = true
+		decision.Remaining -= n
+		r = limiter.NewReservation(decision, now, func() {})
+		return state, nil
+	})
+	if err != nil {
+		return limiter.DeniedReservation(limiter.Decision{Limit: q.quota})
+	}
+	return r
+}
+
+func TestRegisterLimiter_CustomState(t *testing.T) {
+	l, err := limiter.NewRateLimiter("test_quota", map[string]any{"quota": 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !l.Allow("key") || !l.Allow("key") {
+		t.Errorf("expected the quota to allow 2 requests")
+	}
+	if d := l.Decide("key"); d.Allowed || d.RetryAfter <= 0 {
+		t.Errorf("expected the third request to be denied until the period ends, got %+v", d)
+	}
+	if !l.Allow("other") {
+		t.Errorf("expected another key to have its own quota")
+	}
+
+	// Wait goes through WaitN like the built-in limiters
+	if err := l.Wait(context.Background(), "waiting"); err != nil {
+		t.Errorf("expected Wait to go through right away, got %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Wait(ctx, "key"); !errors.Is(err, limiter.ErrWaitExceedsDeadline) {
+		t.Errorf("expected ErrWaitExceedsDeadline, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package limiter
 
 import (
+	"context"
 	"time"
 
 	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
@@ -17,11 +18,13 @@ type FixedWindowLimiter struct {
 	WindowDuration time.Duration
 	WindowSize     int
 	WindowTokens   int
+	runner         bucket.ScriptRunner // runs fixedWindowScript if the bucket can
+	now            func() time.Time
 }
 
 func init() {
 	RegisterLimiter("fixed_window", func(cfg map[string]any) Limiter {
-		return NewFixedWindowLimiter(bucket.NewInMemoryBucket[bucket.FixedWindowBucketType](), FixedWindowConfig{
+		return NewFixedWindowLimiter(bucket.NewInMemoryBucket[bucket.FixedWindowBucketType](bucket.WithJanitor(janitorInterval)), FixedWindowConfig{
 			WindowDuration: cfg["window_duration"].(time.Duration),
 			WindowTokens:   cfg["window_tokens"].(int),
 			WindowSize:     cfg["window_size"].(int),
@@ -48,42 +51,171 @@ func NewFixedWindowLimiter(fwBucket bucket.Bucket[bucket.FixedWindowBucketType],
 		WindowDuration: fwConfig.WindowDuration,
 		WindowTokens:   fwConfig.WindowTokens,
 		WindowSize:     fwConfig.WindowSize,
+		runner:         scriptRunner(fwBucket),
+		now:            time.Now,
 	}
 }
 
 func (f *FixedWindowLimiter) Allow(key string) bool {
-	currentWindow := getCurrentWindow(time.Duration(f.WindowSize) * f.WindowDuration)
+	return f.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow but deducts n tokens from the current window at once.
+func (f *FixedWindowLimiter) AllowN(key string, n int) bool {
+	return f.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports the tokens left in the current
+// window and when the window ends.
+func (f *FixedWindowLimiter) Decide(key string) Decision {
+	return f.DecideN(key, 1)
+}
+
+// DecideN works like Decide but deducts n tokens at once. A cost above
+// WindowTokens is never allowed.
+func (f *FixedWindowLimiter) DecideN(key string, n int) Decision {
+	return f.Reserve(key, n).Decision()
+}
+
+// Reserve reserves n tokens in the current window. Tokens can't be reserved
+// in future windows, so the reservation is either usable right away or not
+// OK, in which case its Decision's RetryAfter points at the window end.
+func (f *FixedWindowLimiter) Reserve(key string, n int) *Reservation {
+	n = normalizeCost(n)
+	windowSize := time.Duration(f.WindowSize) * f.WindowDuration
+	now := f.now()
+	if f.runner != nil {
+		return reserveByScript(f.runner, fixedWindowScript, key, n, now, 0, Decision{Limit: f.WindowTokens, Window: windowSize}, f.WindowTokens, windowSize)
+	}
+	currentWindow := getCurrentWindow(now, windowSize)
+	windowEnd := getWindowEnd(currentWindow, windowSize)
+
+	var r *Reservation
+	err := f.bucket.Update(key, func(fw *bucket.FixedWindowBucketType) (*bucket.FixedWindowBucketType, error) {
+		fw = f.roll(fw, currentWindow, windowEnd)
+
+		decision := Decision{
+			Limit:     f.WindowTokens,
+			Remaining: max(fw.WindowTokens, 0),
+			ResetAt:   windowEnd,
+			Window:    windowSize,
+		}
+
+		// check if there are tokens left
+		if fw.WindowTokens < n {
+			if n <= f.WindowTokens {
+				decision.RetryAfter = windowEnd.Sub(now)
+			}
+			r = DeniedReservation(decision)
+			return fw, nil
+		}
+
+		fw.WindowTokens -= n
+
+		decision.Allowed = true
+		decision.Remaining = fw.WindowTokens
+		r = NewReservation(decision, now, func() {
+			f.refund(key, currentWindow, n)
+		})
+		return fw, nil
+	})
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(Decision{Limit: f.WindowTokens, ResetAt: windowEnd, Window: windowSize})
+	}
+
+	return r
+}
 
+// Charge deducts n tokens from the current window, letting it go negative if
+// there are too few. The debt is forgiven when the next window starts.
+func (f *FixedWindowLimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	windowSize := time.Duration(f.WindowSize) * f.WindowDuration
+	now := f.now()
+	if f.runner != nil {
+		return chargeByScript(f.runner, fixedWindowScript, key, n, now, Decision{Limit: f.WindowTokens, Window: windowSize}, f.WindowTokens, windowSize)
+	}
+	currentWindow := getCurrentWindow(now, windowSize)
+	windowEnd := getWindowEnd(currentWindow, windowSize)
+
+	var decision Decision
+	err := f.bucket.Update(key, func(fw *bucket.FixedWindowBucketType) (*bucket.FixedWindowBucketType, error) {
+		fw = f.roll(fw, currentWindow, windowEnd)
+		fw.WindowTokens -= n
+
+		decision = Decision{
+			Allowed:   fw.WindowTokens >= 1,
+			Limit:     f.WindowTokens,
+			Remaining: max(fw.WindowTokens, 0),
+			ResetAt:   windowEnd,
+			Window:    windowSize,
+		}
+		if !decision.Allowed {
+			decision.RetryAfter = windowEnd.Sub(now)
+		}
+		return fw, nil
+	})
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return Decision{Limit: f.WindowTokens, ResetAt: windowEnd, Window: windowSize}
+	}
+
+	return decision
+}
+
+// Wait blocks until key has capacity for one request or ctx is done. It fails
+// with ErrWaitExceedsDeadline right away if the wait would run past ctx's
+// deadline.
+func (f *FixedWindowLimiter) Wait(ctx context.Context, key string) error {
+	return WaitN(ctx, f, key, 1)
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (f *FixedWindowLimiter) Close() error {
+	return closeBucket(f.bucket)
+}
+
+// roll returns the key's window, created if the key doesn't exist and started
+// over if we moved into a new window since it was last used.
+func (f *FixedWindowLimiter) roll(fw *bucket.FixedWindowBucketType, currentWindow int64, windowEnd time.Time) *bucket.FixedWindowBucketType {
 	// check if the key exists
-	fw := f.bucket.Get(key)
 	if fw == nil {
 		fw = &bucket.FixedWindowBucketType{
 			CurrentWindow: currentWindow,
 			WindowTokens:  f.WindowTokens,
 			Capacity:      f.WindowTokens,
 		}
-		f.bucket.Set(key, fw)
 	}
 
-	// check if it's in the current window
-	if fw.CurrentWindow == currentWindow {
-		// check if there are tokens left
-		if fw.WindowTokens > 0 {
-			fw.WindowTokens--
-			f.bucket.Set(key, fw)
-			return true
-		}
-	} else {
+	// start over if we moved into a new window
+	if fw.CurrentWindow != currentWindow {
 		fw.CurrentWindow = currentWindow
-		fw.WindowTokens = f.WindowTokens - 1
-		f.bucket.Set(key, fw)
-		return true
+		fw.WindowTokens = f.WindowTokens
 	}
+	fw.WindowEnd = windowEnd
+	return fw
+}
+
+// refund puts n tokens back in the key's window, as long as it's still the
+// window they were taken from.
+func (f *FixedWindowLimiter) refund(key string, window int64, n int) {
+	f.bucket.Update(key, func(fw *bucket.FixedWindowBucketType) (*bucket.FixedWindowBucketType, error) {
+		if fw == nil || fw.CurrentWindow != window {
+			return nil, nil
+		}
+
+		fw.WindowTokens = min(f.WindowTokens, fw.WindowTokens+n)
+		return fw, nil
+	})
+}
 
-	return false
+func getCurrentWindow(now time.Time, windowSize time.Duration) int64 {
+	return now.Unix() / int64(windowSize.Seconds())
 }
 
-func getCurrentWindow(windowSize time.Duration) int64 {
-	now := time.Now().Unix()
-	return now / int64(windowSize.Seconds())
+// getWindowEnd returns the time the given window ends and the next one starts
+func getWindowEnd(window int64, windowSize time.Duration) time.Time {
+	return time.Unix((window+1)*int64(windowSize.Seconds()), 0)
 }
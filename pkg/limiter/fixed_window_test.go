@@ -20,6 +20,17 @@ func (m *mockFixedWindowBucket) Set(key string, bucket *bucket.FixedWindowBucket
 	return nil
 }
 
+func (m *mockFixedWindowBucket) Update(key string, fn bucket.UpdateFunc[bucket.FixedWindowBucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
 func (m *mockFixedWindowBucket) Delete(key string) error {
 	delete(m.store, key)
 	return nil
@@ -98,3 +109,99 @@ func TestFixedWindowLimiter_Allow_Burst(t *testing.T) {
 		t.Errorf("expected tokens still=0, got %d", tb.WindowTokens)
 	}
 }
+
+func TestFixedWindowLimiter_Decide(t *testing.T) {
+	mockBucket := &mockFixedWindowBucket{store: make(map[string]*bucket.FixedWindowBucketType)}
+	limiter := NewFixedWindowLimiter(mockBucket, FixedWindowConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   2,
+	})
+
+	key := "decidekey"
+
+	decision := limiter.Decide(key)
+	if !decision.Allowed || decision.Limit != 2 || decision.Remaining != 1 {
+		t.Errorf("expected allowed with limit=2 remaining=1, got %+v", decision)
+	}
+
+	windowEnd := getWindowEnd(mockBucket.Get(key).CurrentWindow, time.Minute)
+	if !decision.ResetAt.Equal(windowEnd) {
+		t.Errorf("expected ResetAt at the end of the window %v, got %v", windowEnd, decision.ResetAt)
+	}
+	if decision.Window != time.Minute {
+		t.Errorf("expected a window of a minute, got %s", decision.Window)
+	}
+
+	limiter.Decide(key)
+	decision = limiter.Decide(key)
+	if decision.Allowed {
+		t.Errorf("expected Decide to deny once the window is exhausted")
+	}
+	if decision.RetryAfter <= 0 || decision.RetryAfter > time.Minute {
+		t.Errorf("expected RetryAfter until the window ends, got %s", decision.RetryAfter)
+	}
+}
+
+func TestFixedWindowLimiter_AllowN(t *testing.T) {
+	mockBucket := &mockFixedWindowBucket{store: make(map[string]*bucket.FixedWindowBucketType)}
+	limiter := NewFixedWindowLimiter(mockBucket, FixedWindowConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   10,
+	})
+
+	key := "weightedkey"
+
+	if !limiter.AllowN(key, 8) {
+		t.Errorf("expected AllowN(8) to be allowed in a fresh window")
+	}
+
+	fw := mockBucket.Get(key)
+	if fw.WindowTokens != 2 {
+		t.Errorf("expected WindowTokens=2, got %d", fw.WindowTokens)
+	}
+
+	if limiter.AllowN(key, 3) {
+		t.Errorf("expected AllowN(3) to be denied with 2 tokens left")
+	}
+	if fw.WindowTokens != 2 {
+		t.Errorf("expected WindowTokens still=2, got %d", fw.WindowTokens)
+	}
+
+	if decision := limiter.DecideN(key, 11); decision.Allowed || decision.RetryAfter != 0 {
+		t.Errorf("expected a cost above WindowTokens to be denied for good, got %+v", decision)
+	}
+}
+
+func TestFixedWindowLimiter_Reserve(t *testing.T) {
+	mockBucket := &mockFixedWindowBucket{store: make(map[string]*bucket.FixedWindowBucketType)}
+	limiter := NewFixedWindowLimiter(mockBucket, FixedWindowConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   3,
+	})
+
+	key := "reservekey"
+
+	r := limiter.Reserve(key, 3)
+	if !r.OK() || r.Delay() != 0 {
+		t.Fatalf("expected reservation in the current window, got ok=%v", r.OK())
+	}
+
+	// Future windows can't be reserved
+	denied := limiter.Reserve(key, 1)
+	if denied.OK() {
+		t.Errorf("expected reservation to fail in an exhausted window")
+	}
+	if denied.Decision().RetryAfter <= 0 {
+		t.Errorf("expected the denied reservation to point at the window end")
+	}
+
+	// the reservation came due right away, only a refund gives the tokens back
+	r.Cancel()
+	if fw := mockBucket.Get(key); fw.WindowTokens != 0 {
+		t.Errorf("expected cancel to do nothing once the reservation came due, got %d tokens", fw.WindowTokens)
+	}
+	r.Refund()
+	if fw := mockBucket.Get(key); fw.WindowTokens != 3 {
+		t.Errorf("expected tokens back after refund, got %d", fw.WindowTokens)
+	}
+}
@@ -0,0 +1,262 @@
+package limiter
+
+import (
+	"context"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type GCRAConfig struct {
+	Rate   int           // number of requests allowed per period
+	Period time.Duration // period the rate applies to, seconds, minutes etc
+	Burst  int           // number of requests that can be made at once
+}
+
+// GCRALimiter implements the generic cell rate algorithm. Instead of counting
+// tokens it keeps the theoretical arrival time (TAT) of the next request per
+// key; a request is allowed as long as it doesn't arrive earlier than the TAT
+// minus the burst tolerance.
+type GCRALimiter struct {
+	bucket           bucket.Bucket[bucket.GCRABucketType]
+	Rate             int
+	Period           time.Duration
+	Burst            int
+	emissionInterval time.Duration       // time between two requests at a steady rate
+	burstTolerance   time.Duration       // how far ahead of now the TAT may run
+	runner           bucket.ScriptRunner // runs gcraScript if the bucket can
+	now              func() time.Time
+}
+
+func init() {
+	RegisterLimiter("gcra", func(cfg map[string]any) Limiter {
+		return NewGCRALimiter(bucket.NewInMemoryBucket[bucket.GCRABucketType](bucket.WithJanitor(janitorInterval)), GCRAConfig{
+			Rate:   cfg["rate"].(int),
+			Period: cfg["period"].(time.Duration),
+			Burst:  cfg["burst"].(int),
+		})
+	})
+}
+
+// NewGCRALimiter creates a new GCRALimiter with the given bucket and config.
+// If the config's Rate or Period is 0, it will be set to the default values of 5 and 1s respectively.
+// If Burst is 0 it defaults to Rate, allowing a full period's worth of requests at once.
+func NewGCRALimiter(gcraBucket bucket.Bucket[bucket.GCRABucketType], gcraConfig GCRAConfig) *GCRALimiter {
+	if gcraConfig.Rate == 0 {
+		gcraConfig.Rate = 5
+	}
+
+	if gcraConfig.Period == 0 {
+		gcraConfig.Period = time.Second
+	}
+
+	if gcraConfig.Burst == 0 {
+		gcraConfig.Burst = gcraConfig.Rate
+	}
+
+	emissionInterval := gcraConfig.Period / time.Duration(gcraConfig.Rate)
+
+	return &GCRALimiter{
+		bucket:           gcraBucket,
+		Rate:             gcraConfig.Rate,
+		Period:           gcraConfig.Period,
+		Burst:            gcraConfig.Burst,
+		emissionInterval: emissionInterval,
+		burstTolerance:   emissionInterval * time.Duration(gcraConfig.Burst),
+		runner:           scriptRunner(gcraBucket),
+		now:              time.Now,
+	}
+}
+
+// Allow returns true if the request arrives no earlier than the key's TAT
+// minus the burst tolerance, false otherwise. Allowed requests push the TAT
+// forward by one emission interval.
+func (g *GCRALimiter) Allow(key string) bool {
+	return g.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow but pushes the TAT forward by n emission intervals.
+func (g *GCRALimiter) AllowN(key string, n int) bool {
+	return g.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports how much burst is left and, if
+// denied, exactly when the request would conform again.
+func (g *GCRALimiter) Decide(key string) Decision {
+	return g.DecideN(key, 1)
+}
+
+// DecideN works like Decide for a request costing n. A cost above Burst is
+// never allowed.
+func (g *GCRALimiter) DecideN(key string, n int) Decision {
+	return g.decideAt(key, normalizeCost(n), g.now())
+}
+
+// Reserve pushes the key's TAT forward by n emission intervals even if the
+// request doesn't conform yet, the reservation's Delay is then the time until
+// it does. A cost above Burst can't be reserved.
+func (g *GCRALimiter) Reserve(key string, n int) *Reservation {
+	return g.reserveAt(key, normalizeCost(n), g.now(), InfDuration)
+}
+
+// Wait blocks until key has capacity for one request or ctx is done. It fails
+// with ErrWaitExceedsDeadline right away if the wait would run past ctx's
+// deadline.
+func (g *GCRALimiter) Wait(ctx context.Context, key string) error {
+	return WaitN(ctx, g, key, 1)
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (g *GCRALimiter) Close() error {
+	return closeBucket(g.bucket)
+}
+
+// Charge pushes the key's TAT forward by n emission intervals, however far
+// ahead of now that puts it.
+func (g *GCRALimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	now := g.now()
+	if g.runner != nil {
+		return chargeByScript(g.runner, gcraScript, key, n, now, Decision{Limit: g.Burst, Window: g.burstTolerance}, g.emissionInterval, g.Burst)
+	}
+
+	var decision Decision
+	err := g.bucket.Update(key, func(gcra *bucket.GCRABucketType) (*bucket.GCRABucketType, error) {
+		gcra, tat := g.tatAt(gcra, now)
+		gcra.TAT = tat.Add(time.Duration(n) * g.emissionInterval)
+
+		// the next request conforms once the TAT is back within the burst
+		// tolerance of now
+		wait := max(gcra.TAT.Add(g.emissionInterval-g.burstTolerance).Sub(now), 0)
+		decision = Decision{
+			Allowed:    wait == 0,
+			Limit:      g.Burst,
+			Remaining:  g.remaining(gcra.TAT, now),
+			ResetAt:    gcra.TAT,
+			RetryAfter: wait,
+			Window:     g.burstTolerance,
+		}
+		return gcra, nil
+	})
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return Decision{Limit: g.Burst, Window: g.burstTolerance}
+	}
+
+	return decision
+}
+
+func (g *GCRALimiter) decideAt(key string, n int, now time.Time) Decision {
+	return g.reserveAt(key, n, now, 0).Decision()
+}
+
+func (g *GCRALimiter) reserveAt(key string, n int, now time.Time, maxWait time.Duration) *Reservation {
+	if g.runner != nil {
+		return reserveByScript(g.runner, gcraScript, key, n, now, maxWait, Decision{Limit: g.Burst, Window: g.burstTolerance}, g.emissionInterval, g.Burst)
+	}
+
+	var r *Reservation
+	err := g.bucket.Update(key, func(gcra *bucket.GCRABucketType) (*bucket.GCRABucketType, error) {
+		gcra, tat := g.tatAt(gcra, now)
+
+		decision := Decision{
+			Limit:     g.Burst,
+			Remaining: g.remaining(tat, now),
+			ResetAt:   tat,
+			Window:    g.burstTolerance,
+		}
+
+		// more than the burst tolerance can ever absorb
+		if n > g.Burst {
+			r = DeniedReservation(decision)
+			return nil, nil
+		}
+
+		newTAT := tat.Add(time.Duration(n) * g.emissionInterval)
+		allowAt := newTAT.Add(-g.burstTolerance)
+		if allowAt.Before(now) {
+			allowAt = now
+		}
+
+		wait := allowAt.Sub(now)
+		if wait > maxWait {
+			decision.RetryAfter = wait
+			r = DeniedReservation(decision)
+			return nil, nil
+		}
+
+		gcra.TAT = newTAT
+
+		decision.Allowed = wait == 0
+		decision.Remaining = g.remaining(newTAT, now)
+		decision.ResetAt = newTAT
+		decision.RetryAfter = wait
+		r = newReservation(decision, allowAt, func(time.Time) {
+			g.cancel(key, n, newTAT)
+		}, func() {
+			g.refund(key, n)
+		})
+		return gcra, nil
+	})
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(Decision{Limit: g.Burst, Window: g.burstTolerance})
+	}
+
+	return r
+}
+
+// tatAt returns the key's state, created if the key doesn't exist, and the
+// TAT to schedule from at now.
+func (g *GCRALimiter) tatAt(gcra *bucket.GCRABucketType, now time.Time) (*bucket.GCRABucketType, time.Time) {
+	if gcra == nil {
+		gcra = &bucket.GCRABucketType{
+			TAT: now,
+		}
+	}
+
+	// a TAT in the past means the key has been idle, start from now
+	tat := gcra.TAT
+	if tat.Before(now) {
+		tat = now
+	}
+	return gcra, tat
+}
+
+// cancel moves the key's TAT back by the n emission intervals of the
+// reservation that pushed it to tat, less the intervals reserved after it:
+// those reservations' slots stay theirs.
+func (g *GCRALimiter) cancel(key string, n int, tat time.Time) {
+	g.bucket.Update(key, func(gcra *bucket.GCRABucketType) (*bucket.GCRABucketType, error) {
+		if gcra == nil {
+			return nil, nil
+		}
+
+		restore := time.Duration(n)*g.emissionInterval - gcra.TAT.Sub(tat)
+		if restore <= 0 {
+			return nil, nil
+		}
+
+		gcra.TAT = gcra.TAT.Add(-restore)
+		return gcra, nil
+	})
+}
+
+// refund moves the key's TAT back by n emission intervals.
+func (g *GCRALimiter) refund(key string, n int) {
+	g.bucket.Update(key, func(gcra *bucket.GCRABucketType) (*bucket.GCRABucketType, error) {
+		if gcra == nil {
+			return nil, nil
+		}
+
+		gcra.TAT = gcra.TAT.Add(-time.Duration(n) * g.emissionInterval)
+		return gcra, nil
+	})
+}
+
+// remaining returns how many more requests fit in the burst tolerance given
+// the key's TAT.
+func (g *GCRALimiter) remaining(tat time.Time, now time.Time) int {
+	return max(int((g.burstTolerance-tat.Sub(now))/g.emissionInterval), 0)
+}
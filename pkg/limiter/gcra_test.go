@@ -0,0 +1,218 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type mockGCRABucket struct {
+	store map[string]*bucket.GCRABucketType
+}
+
+func (m *mockGCRABucket) Get(key string) *bucket.GCRABucketType {
+	return m.store[key]
+}
+
+func (m *mockGCRABucket) Set(key string, bucket *bucket.GCRABucketType) error {
+	m.store[key] = bucket
+	return nil
+}
+
+func (m *mockGCRABucket) Update(key string, fn bucket.UpdateFunc[bucket.GCRABucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
+func (m *mockGCRABucket) Delete(key string) error {
+	delete(m.store, key)
+	return nil
+}
+
+func (m *mockGCRABucket) Clear() {
+	m.store = make(map[string]*bucket.GCRABucketType)
+}
+
+func TestNewGCRALimiter_Defaults(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{})
+
+	if limiter.Rate != 5 {
+		t.Errorf("expected Rate to be 5, got %d", limiter.Rate)
+	}
+
+	if limiter.Period != time.Second {
+		t.Errorf("expected Period to be 1s, got %s", limiter.Period)
+	}
+
+	if limiter.Burst != 5 {
+		t.Errorf("expected Burst to default to Rate, got %d", limiter.Burst)
+	}
+
+	if limiter.emissionInterval != 200*time.Millisecond {
+		t.Errorf("expected emission interval of 200ms, got %s", limiter.emissionInterval)
+	}
+}
+
+func TestGCRALimiter_Allow_NewKey(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{})
+
+	key := "newkey"
+	now := time.Now()
+	if !limiter.decideAt(key, 1, now).Allowed {
+		t.Errorf("expected Allow to return true for new key")
+	}
+
+	gcra := mockBucket.Get(key)
+	if gcra == nil {
+		t.Fatalf("expected gcra bucket to be created")
+	}
+
+	if !gcra.TAT.Equal(now.Add(200 * time.Millisecond)) {
+		t.Errorf("expected TAT one emission interval ahead, got %v", gcra.TAT.Sub(now))
+	}
+}
+
+func TestGCRALimiter_Allow_Burst(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{
+		Rate:   1,
+		Period: time.Second,
+		Burst:  3,
+	})
+
+	key := "burstkey"
+	now := time.Now()
+
+	// First 3 calls should be allowed
+	for i := 1; i <= 3; i++ {
+		if !limiter.decideAt(key, 1, now).Allowed {
+			t.Errorf("expected Allow to return true on call %d", i)
+		}
+	}
+
+	// Next call should be denied and leave the TAT untouched
+	tat := mockBucket.Get(key).TAT
+	if limiter.decideAt(key, 1, now).Allowed {
+		t.Errorf("expected Allow to return false after burst")
+	}
+	if !mockBucket.Get(key).TAT.Equal(tat) {
+		t.Errorf("expected TAT not to move on a denied request")
+	}
+}
+
+func TestGCRALimiter_Allow_SteadyRate(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{
+		Rate:   1,
+		Period: time.Second,
+		Burst:  1,
+	})
+
+	key := "steadykey"
+	now := time.Now()
+
+	if !limiter.decideAt(key, 1, now).Allowed {
+		t.Errorf("expected first request to be allowed")
+	}
+	if limiter.decideAt(key, 1, now.Add(500*time.Millisecond)).Allowed {
+		t.Errorf("expected request before the emission interval to be denied")
+	}
+	if !limiter.decideAt(key, 1, now.Add(time.Second)).Allowed {
+		t.Errorf("expected request after the emission interval to be allowed")
+	}
+}
+
+func TestGCRALimiter_Allow_IdleKeyResets(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{
+		Rate:   1,
+		Period: time.Second,
+		Burst:  2,
+	})
+
+	key := "idlekey"
+	now := time.Now()
+	mockBucket.Set(key, &bucket.GCRABucketType{TAT: now.Add(-time.Hour)})
+
+	// An old TAT must not bank extra burst capacity
+	for i := 1; i <= 2; i++ {
+		if !limiter.decideAt(key, 1, now).Allowed {
+			t.Errorf("expected Allow to return true on call %d", i)
+		}
+	}
+	if limiter.decideAt(key, 1, now).Allowed {
+		t.Errorf("expected Allow to return false after burst")
+	}
+}
+
+func TestGCRALimiter_Decide(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{
+		Rate:   2,
+		Period: time.Second,
+		Burst:  2,
+	})
+
+	key := "decidekey"
+	now := time.Now()
+
+	decision := limiter.decideAt(key, 1, now)
+	if !decision.Allowed || decision.Limit != 2 || decision.Remaining != 1 {
+		t.Errorf("expected allowed with limit=2 remaining=1, got %+v", decision)
+	}
+	if decision.Window != time.Second {
+		t.Errorf("expected the burst to refill in a second, got %s", decision.Window)
+	}
+
+	limiter.decideAt(key, 1, now)
+	decision = limiter.decideAt(key, 1, now)
+	if decision.Allowed {
+		t.Fatalf("expected Decide to deny after burst")
+	}
+	if decision.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected RetryAfter of one emission interval, got %s", decision.RetryAfter)
+	}
+	if !decision.ResetAt.Equal(now.Add(time.Second)) {
+		t.Errorf("expected ResetAt when the TAT is reached, got %v", decision.ResetAt.Sub(now))
+	}
+	if !limiter.decideAt(key, 1, now.Add(decision.RetryAfter)).Allowed {
+		t.Errorf("expected request to be allowed after RetryAfter")
+	}
+}
+
+func TestGCRALimiter_Reserve(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{
+		Rate:   1,
+		Period: time.Second,
+		Burst:  2,
+	})
+
+	key := "reservekey"
+	now := time.Now()
+
+	limiter.decideAt(key, 1, now)
+	tat := mockBucket.Get(key).TAT
+
+	r := limiter.reserveAt(key, 2, now, InfDuration)
+	if !r.OK() {
+		t.Fatalf("expected reservation to be OK")
+	}
+	if delay := r.DelayFrom(now); delay != time.Second {
+		t.Errorf("expected a delay of 1s, got %s", delay)
+	}
+
+	r.Cancel()
+	if !mockBucket.Get(key).TAT.Equal(tat) {
+		t.Errorf("expected cancel to restore the TAT")
+	}
+}
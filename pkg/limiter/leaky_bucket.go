@@ -0,0 +1,272 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+// ErrQueueFull is returned by LeakyBucketLimiter.Wait when the key's queue
+// has no room left for another request.
+var ErrQueueFull = errors.New("Leaky bucket queue is full")
+
+type LeakyBucketConfig struct {
+	LeakRate  float64 // requests released per second
+	QueueSize int     // number of requests that can wait to be released
+}
+
+// LeakyBucketLimiter shapes traffic to a constant rate. Requests for a key are
+// queued and released one every 1/LeakRate seconds; only requests that don't
+// fit in the queue are rejected. Its decisions count the request being
+// released and the queued ones against a Limit of QueueSize+1.
+type LeakyBucketLimiter struct {
+	bucket    bucket.Bucket[bucket.LeakyBucketType]
+	LeakRate  float64
+	QueueSize int
+	interval  time.Duration       // time between two released requests
+	runner    bucket.ScriptRunner // runs leakyBucketScript if the bucket can
+	now       func() time.Time
+}
+
+func init() {
+	RegisterLimiter("leaky_bucket", func(cfg map[string]any) Limiter {
+		return NewLeakyBucketLimiter(bucket.NewInMemoryBucket[bucket.LeakyBucketType](bucket.WithJanitor(janitorInterval)), LeakyBucketConfig{
+			LeakRate:  cfg["leak_rate"].(float64),
+			QueueSize: cfg["queue_size"].(int),
+		})
+	})
+}
+
+// NewLeakyBucketLimiter creates a new LeakyBucketLimiter with the given bucket and config.
+// If the config's LeakRate or QueueSize is 0, it will be set to the default values of 1 and 5 respectively.
+func NewLeakyBucketLimiter(lbBucket bucket.Bucket[bucket.LeakyBucketType], lbConfig LeakyBucketConfig) *LeakyBucketLimiter {
+	if lbConfig.LeakRate == 0 {
+		lbConfig.LeakRate = 1
+	}
+
+	if lbConfig.QueueSize == 0 {
+		lbConfig.QueueSize = 5
+	}
+
+	return &LeakyBucketLimiter{
+		bucket:    lbBucket,
+		LeakRate:  lbConfig.LeakRate,
+		QueueSize: lbConfig.QueueSize,
+		interval:  time.Duration(float64(time.Second) / lbConfig.LeakRate),
+		runner:    scriptRunner(lbBucket),
+		now:       time.Now,
+	}
+}
+
+// Allow is the non-blocking variant of Wait. It returns true only if the
+// request can be released right away, it never queues the request.
+func (l *LeakyBucketLimiter) Allow(key string) bool {
+	return l.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow for a request costing n. The request is released
+// right away but holds back the key's next release for n drain intervals.
+func (l *LeakyBucketLimiter) AllowN(key string, n int) bool {
+	return l.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports when the queue has drained and,
+// if denied, when the next release slot opens up.
+func (l *LeakyBucketLimiter) Decide(key string) Decision {
+	return l.DecideN(key, 1)
+}
+
+// DecideN works like Decide for a request costing n.
+func (l *LeakyBucketLimiter) DecideN(key string, n int) Decision {
+	return l.reserve(key, normalizeCost(n), 0).Decision()
+}
+
+// Reserve queues a request costing n and returns a reservation whose Delay is
+// the time until it is released. The reservation is not OK if the queue is full.
+func (l *LeakyBucketLimiter) Reserve(key string, n int) *Reservation {
+	return l.reserve(key, normalizeCost(n), time.Duration(l.QueueSize)*l.interval)
+}
+
+// Wait queues the request and blocks until it is released or ctx is done.
+// It returns ErrQueueFull straight away if the queue for key is full, and
+// ErrWaitExceedsDeadline if the request wouldn't be released before ctx's
+// deadline. If ctx is done first the request leaves the queue again.
+func (l *LeakyBucketLimiter) Wait(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r := l.Reserve(key, 1)
+	if !r.OK() {
+		return ErrQueueFull
+	}
+
+	wait := r.Delay()
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+		r.Cancel()
+		return ErrWaitExceedsDeadline
+	}
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (l *LeakyBucketLimiter) Close() error {
+	return closeBucket(l.bucket)
+}
+
+// Charge keeps the key's queue busy for n more drain intervals, after the
+// requests already scheduled.
+func (l *LeakyBucketLimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	now := l.now()
+	if l.runner != nil {
+		return chargeByScript(l.runner, leakyBucketScript, key, n, now, Decision{Limit: l.QueueSize + 1, Window: l.interval}, l.interval, l.QueueSize)
+	}
+	wait, _, err := l.schedule(key, now, InfDuration, n)
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return Decision{Limit: l.QueueSize + 1, Window: l.interval}
+	}
+
+	// the queue is free again once the charged intervals have drained
+	busy := wait + time.Duration(n)*l.interval
+	return Decision{
+		Allowed:    false,
+		Limit:      l.QueueSize + 1,
+		Remaining:  l.remaining(now.Add(busy-l.interval), now),
+		ResetAt:    now.Add(busy),
+		RetryAfter: busy,
+		Window:     l.interval,
+	}
+}
+
+func (l *LeakyBucketLimiter) reserve(key string, n int, maxWait time.Duration) *Reservation {
+	now := l.now()
+	if l.runner != nil {
+		return reserveByScript(l.runner, leakyBucketScript, key, n, now, maxWait, Decision{Limit: l.QueueSize + 1, Window: l.interval}, l.interval, l.QueueSize)
+	}
+	wait, ok, err := l.schedule(key, now, maxWait, n)
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(Decision{Limit: l.QueueSize + 1, Window: l.interval})
+	}
+
+	if !ok {
+		// the next free slot is one interval after the last release
+		return DeniedReservation(Decision{
+			Allowed:    false,
+			Limit:      l.QueueSize + 1,
+			Remaining:  l.remaining(now.Add(wait-l.interval), now),
+			ResetAt:    now.Add(wait),
+			RetryAfter: wait,
+			Window:     l.interval,
+		})
+	}
+
+	lastRelease := now.Add(wait + time.Duration(n-1)*l.interval)
+	return newReservation(Decision{
+		Allowed:    wait == 0,
+		Limit:      l.QueueSize + 1,
+		Remaining:  l.remaining(lastRelease, now),
+		ResetAt:    lastRelease.Add(l.interval),
+		RetryAfter: wait,
+		Window:     l.interval,
+	}, now.Add(wait), func(time.Time) {
+		l.cancel(key, n, lastRelease)
+	}, func() {
+		l.refund(key, n)
+	})
+}
+
+// remaining returns the release slots still free at now, out of the request
+// being released and the QueueSize waiting, if the key's last request is
+// released at lastRelease.
+func (l *LeakyBucketLimiter) remaining(lastRelease time.Time, now time.Time) int {
+	busy := lastRelease.Add(l.interval).Sub(now)
+	if busy <= 0 {
+		return l.QueueSize + 1
+	}
+
+	taken := int((busy + l.interval - 1) / l.interval)
+	return max(l.QueueSize+1-taken, 0)
+}
+
+// cancel gives back the n drain intervals of the reservation whose last one
+// was released at lastRelease, less the intervals reserved after it: those
+// reservations' slots stay theirs.
+func (l *LeakyBucketLimiter) cancel(key string, n int, lastRelease time.Time) {
+	l.bucket.Update(key, func(lb *bucket.LeakyBucketType) (*bucket.LeakyBucketType, error) {
+		if lb == nil {
+			return nil, nil
+		}
+
+		restore := time.Duration(n)*l.interval - lb.LastRelease.Sub(lastRelease)
+		if restore <= 0 {
+			return nil, nil
+		}
+
+		lb.LastRelease = lb.LastRelease.Add(-restore)
+		return lb, nil
+	})
+}
+
+// refund gives the n drain intervals a request held in the queue back.
+func (l *LeakyBucketLimiter) refund(key string, n int) {
+	l.bucket.Update(key, func(lb *bucket.LeakyBucketType) (*bucket.LeakyBucketType, error) {
+		if lb == nil {
+			return nil, nil
+		}
+
+		lb.LastRelease = lb.LastRelease.Add(-time.Duration(n) * l.interval)
+		return lb, nil
+	})
+}
+
+// schedule reserves the next release slot for key and returns how long the
+// caller has to wait for it. A request costing n keeps the queue busy for n
+// drain intervals. Slots further than maxWait away are not reserved, the
+// returned duration is then the wait for the next free slot.
+func (l *LeakyBucketLimiter) schedule(key string, now time.Time, maxWait time.Duration, n int) (time.Duration, bool, error) {
+	var wait time.Duration
+	var ok bool
+	err := l.bucket.Update(key, func(lb *bucket.LeakyBucketType) (*bucket.LeakyBucketType, error) {
+		if lb == nil {
+			lb = &bucket.LeakyBucketType{}
+		}
+
+		// the next slot is one interval after the last release, or now if the
+		// queue has drained
+		slot := now
+		if next := lb.LastRelease.Add(l.interval); next.After(now) {
+			slot = next
+		}
+
+		wait = slot.Sub(now)
+		ok = wait <= maxWait
+		if !ok {
+			return nil, nil
+		}
+
+		lb.LastRelease = slot.Add(time.Duration(n-1) * l.interval)
+		lb.Interval = l.interval
+		return lb, nil
+	})
+	return wait, ok, err
+}
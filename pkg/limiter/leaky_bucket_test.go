@@ -0,0 +1,215 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type mockLeakyBucket struct {
+	store map[string]*bucket.LeakyBucketType
+}
+
+func (m *mockLeakyBucket) Get(key string) *bucket.LeakyBucketType {
+	return m.store[key]
+}
+
+func (m *mockLeakyBucket) Set(key string, bucket *bucket.LeakyBucketType) error {
+	m.store[key] = bucket
+	return nil
+}
+
+func (m *mockLeakyBucket) Update(key string, fn bucket.UpdateFunc[bucket.LeakyBucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
+func (m *mockLeakyBucket) Delete(key string) error {
+	delete(m.store, key)
+	return nil
+}
+
+func (m *mockLeakyBucket) Clear() {
+	m.store = make(map[string]*bucket.LeakyBucketType)
+}
+
+func TestNewLeakyBucketLimiter_Defaults(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{})
+
+	if limiter.LeakRate != 1 {
+		t.Errorf("expected LeakRate to be 1, got %f", limiter.LeakRate)
+	}
+
+	if limiter.QueueSize != 5 {
+		t.Errorf("expected QueueSize to be 5, got %d", limiter.QueueSize)
+	}
+
+	if limiter.interval != time.Second {
+		t.Errorf("expected interval of 1s, got %s", limiter.interval)
+	}
+}
+
+func TestLeakyBucketLimiter_Schedule_QueuesAtFixedIntervals(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate:  1,
+		QueueSize: 2,
+	})
+
+	key := "queuekey"
+	now := time.Now()
+	maxWait := 2 * time.Second
+
+	// The first request is released immediately, the next two are queued
+	for i, expected := range []time.Duration{0, time.Second, 2 * time.Second} {
+		wait, ok, _ := limiter.schedule(key, now, maxWait, 1)
+		if !ok {
+			t.Fatalf("expected request %d to be queued", i+1)
+		}
+		if wait != expected {
+			t.Errorf("expected request %d to wait %s, got %s", i+1, expected, wait)
+		}
+	}
+
+	// The queue is full now
+	if _, ok, _ := limiter.schedule(key, now, maxWait, 1); ok {
+		t.Errorf("expected request to be rejected when the queue is full")
+	}
+
+	// After the queue drained a slot frees up again
+	wait, ok, _ := limiter.schedule(key, now.Add(time.Second), maxWait, 1)
+	if !ok || wait != 2*time.Second {
+		t.Errorf("expected a slot 2s out after one release, got %s ok=%v", wait, ok)
+	}
+}
+
+func TestLeakyBucketLimiter_Allow_DoesNotQueue(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate: 1,
+	})
+
+	key := "allowkey"
+	if !limiter.Allow(key) {
+		t.Errorf("expected Allow to return true for new key")
+	}
+
+	lastRelease := mockBucket.Get(key).LastRelease
+	if limiter.Allow(key) {
+		t.Errorf("expected Allow to return false instead of queueing")
+	}
+	if !mockBucket.Get(key).LastRelease.Equal(lastRelease) {
+		t.Errorf("expected a rejected Allow not to reserve a slot")
+	}
+}
+
+func TestLeakyBucketLimiter_Decision_ReportsQueueSlots(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate:  0.1,
+		QueueSize: 2,
+	})
+
+	key := "slotskey"
+	decision := limiter.Decide(key)
+	if !decision.Allowed || decision.Limit != 3 || decision.Remaining != 2 {
+		t.Errorf("expected the released request to take 1 of 3 slots, got %+v", decision)
+	}
+
+	for _, remaining := range []int{1, 0} {
+		r := limiter.Reserve(key, 1)
+		if !r.OK() || r.Decision().Remaining != remaining {
+			t.Errorf("expected a queued request leaving %d slots, got %+v", remaining, r.Decision())
+		}
+	}
+
+	if r := limiter.Reserve(key, 1); r.OK() || r.Decision().Limit != 3 || r.Decision().Remaining != 0 {
+		t.Errorf("expected the full queue to be reported, got %+v", r.Decision())
+	}
+}
+
+func TestLeakyBucketLimiter_Wait(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate:  50,
+		QueueSize: 1,
+	})
+
+	key := "waitkey"
+	start := time.Now()
+	for i := 1; i <= 2; i++ {
+		if err := limiter.Wait(context.Background(), key); err != nil {
+			t.Fatalf("expected Wait to succeed on call %d, got %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the second request to be delayed by the drain interval, took %s", elapsed)
+	}
+}
+
+func TestLeakyBucketLimiter_Wait_QueueFull(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate:  0.1,
+		QueueSize: 1,
+	})
+
+	key := "fullkey"
+	mockBucket.Set(key, &bucket.LeakyBucketType{LastRelease: time.Now().Add(10 * time.Second)})
+
+	if err := limiter.Wait(context.Background(), key); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+}
+
+func TestLeakyBucketLimiter_Wait_ContextCancelled(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate: 0.1,
+	})
+
+	key := "cancelkey"
+	limiter.Allow(key)
+	lastRelease := mockBucket.Get(key).LastRelease
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := limiter.Wait(ctx, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if !mockBucket.Get(key).LastRelease.Equal(lastRelease) {
+		t.Errorf("expected the cancelled request to leave the queue")
+	}
+}
+
+func TestLeakyBucketLimiter_Wait_ExceedsDeadline(t *testing.T) {
+	mockBucket := &mockLeakyBucket{store: make(map[string]*bucket.LeakyBucketType)}
+	limiter := NewLeakyBucketLimiter(mockBucket, LeakyBucketConfig{
+		LeakRate: 0.1,
+	})
+
+	key := "deadlinekey"
+	limiter.Allow(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := limiter.Wait(ctx, key); !errors.Is(err, ErrWaitExceedsDeadline) {
+		t.Errorf("expected ErrWaitExceedsDeadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected Wait to fail without sleeping, took %s", elapsed)
+	}
+}
@@ -1,9 +1,44 @@
 package limiter
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
 
 type Limiter interface {
-	Allow(key string) bool // check if request is allowed
+	Allow(key string) bool                      // check if request is allowed
+	AllowN(key string, n int) bool              // check if a request costing n is allowed
+	Decide(key string) Decision                 // check if request is allowed and report the key's state
+	DecideN(key string, n int) Decision         // check if a request costing n is allowed and report the key's state
+	Reserve(key string, n int) *Reservation     // reserve n tokens, possibly in the future
+	Wait(ctx context.Context, key string) error // block until the request is allowed
+}
+
+// Charger is implemented by limiters that can deduct a cost after the fact,
+// e.g. once a handler knows how expensive a request turned out to be. Unlike
+// AllowN the cost is always deducted, even if it puts the key into debt that
+// has to be paid off before its next request is allowed. All the limiters in
+// this package implement it.
+type Charger interface {
+	// Charge deducts n tokens from key and reports its state afterwards.
+	// Allowed is whether the key can make another request right away, if not
+	// RetryAfter is how long until it can.
+	Charge(key string, n int) Decision
+}
+
+// Decision is the outcome of a rate limit check for a key.
+// A request costing more than Limit can never be allowed, it is denied without
+// consuming anything and RetryAfter is left at 0.
+type Decision struct {
+	Allowed    bool          // whether the request is allowed
+	Limit      int           // maximum number of requests the key can make at once
+	Remaining  int           // number of requests the key has left
+	ResetAt    time.Time     // time the key is back to its full limit
+	RetryAfter time.Duration // how long to wait before the next request can be allowed, 0 if allowed
+	Window     time.Duration // time it takes the key to get its full limit back after using it up
 }
 
 // used to create the rate limiter with the default config
@@ -11,7 +46,12 @@ type LimiterFactory func(cfg map[string]any) Limiter
 
 var limiterRegistry = make(map[string]LimiterFactory)
 
-// Creates a new rate limiter
+// how often the buckets created by the registry remove idle keys
+const janitorInterval = time.Minute
+
+// Creates a new rate limiter. The built-in limiters keep their keys in an
+// in-memory bucket with a janitor goroutine removing idle keys, call Close on
+// the limiter, which implements io.Closer, once it's no longer used.
 func NewRateLimiter(name string, cfg map[string]interface{}) (Limiter, error) {
 	if constructor, ok := limiterRegistry[name]; ok {
 		return constructor(cfg), nil
@@ -30,3 +70,27 @@ func RegisterLimiter(name string, factory LimiterFactory) error {
 	limiterRegistry[name] = factory
 	return nil
 }
+
+// closeBucket closes b if it holds resources, like the janitor goroutine of
+// an InMemoryBucket or the connections of a RedisBucket. A bucket shared by
+// several limiters is closed for all of them.
+func closeBucket(b any) error {
+	if closer, ok := b.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// secondsToDuration converts seconds to a duration, rounding up so that
+// waiting the returned duration is always long enough.
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
+
+// normalizeCost makes sure every request costs at least one token
+func normalizeCost(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
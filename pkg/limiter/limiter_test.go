@@ -0,0 +1,58 @@
+package limiter
+
+import (
+	"io"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// newRegistryLimiter builds the named limiter through the registry and closes
+// it when the test is done
+func newRegistryLimiter(t *testing.T, name string, cfg map[string]any) Limiter {
+	t.Helper()
+	l, err := NewRateLimiter(name, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.(io.Closer).Close() })
+	return l
+}
+
+func TestNewRateLimiter_Close(t *testing.T) {
+	configs := map[string]map[string]any{
+		"token_bucket":           {"capacity": 1, "refill_rate": 1.0, "tokens": 1},
+		"fixed_window":           {"window_duration": time.Minute, "window_tokens": 1, "window_size": 1},
+		"sliding_window_log":     {"window_duration": time.Minute, "capacity": 1, "window_size": int64(1)},
+		"sliding_window_counter": {"window_duration": time.Minute, "window_tokens": 1, "window_size": 1},
+		"gcra":                   {"rate": 1, "period": time.Minute, "burst": 1},
+		"leaky_bucket":           {"leak_rate": 1.0, "queue_size": 1},
+	}
+	before := runtime.NumGoroutine()
+
+	for name, cfg := range configs {
+		l, err := NewRateLimiter(name, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		closer, ok := l.(io.Closer)
+		if !ok {
+			t.Fatalf("expected %s to implement io.Closer", name)
+		}
+		if err := closer.Close(); err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if err := closer.Close(); err != nil {
+			t.Errorf("%s: expected Close to be idempotent, got %v", name, err)
+		}
+	}
+
+	// the janitors are gone
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected the janitors to stop, %d goroutines left over", after-before)
+	}
+}
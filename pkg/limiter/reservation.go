@@ -0,0 +1,112 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// InfDuration is the duration returned by Delay when a Reservation is not OK.
+const InfDuration = time.Duration(math.MaxInt64)
+
+// Reservation holds capacity reserved for a key, possibly in the future.
+// It is modeled on golang.org/x/time/rate's Reservation: the caller waits
+// Delay() before acting, or calls Cancel() to give the capacity back.
+type Reservation struct {
+	ok        bool
+	timeToAct time.Time
+	decision  Decision
+	cancel    func(now time.Time) // restores what no later reservation depends on
+	refund    func()              // restores everything that was reserved
+	once      sync.Once
+}
+
+// NewReservation returns an OK reservation that can be acted on at timeToAct.
+// refund is called at most once to give the reserved capacity back, by Cancel
+// before timeToAct or by Refund at any time. It lets limiters outside this
+// package implement Reserve.
+func NewReservation(decision Decision, timeToAct time.Time, refund func()) *Reservation {
+	return newReservation(decision, timeToAct, func(time.Time) { refund() }, refund)
+}
+
+// newReservation works like NewReservation for limiters that can tell which
+// part of a cancelled reservation later reservations depend on; cancel only
+// restores the rest.
+func newReservation(decision Decision, timeToAct time.Time, cancel func(now time.Time), refund func()) *Reservation {
+	return &Reservation{
+		ok:        true,
+		timeToAct: timeToAct,
+		decision:  decision,
+		cancel:    cancel,
+		refund:    refund,
+	}
+}
+
+// DeniedReservation returns a reservation that is not OK, nothing was reserved.
+func DeniedReservation(decision Decision) *Reservation {
+	return &Reservation{
+		decision: decision,
+	}
+}
+
+// OK returns whether the limiter could reserve the requested capacity.
+// If OK is false, Delay returns InfDuration and Cancel and Refund do nothing.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay is shorthand for DelayFrom(time.Now()).
+func (r *Reservation) Delay() time.Duration {
+	return r.DelayFrom(time.Now())
+}
+
+// DelayFrom returns how long the caller has to wait from t before acting on
+// the reservation. Zero means act immediately, InfDuration means the
+// reservation is not OK.
+func (r *Reservation) DelayFrom(t time.Time) time.Duration {
+	if !r.ok {
+		return InfDuration
+	}
+
+	delay := r.timeToAct.Sub(t)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// Cancel is shorthand for CancelAt(time.Now()).
+func (r *Reservation) Cancel() {
+	r.CancelAt(time.Now())
+}
+
+// CancelAt gives the reserved capacity back to the key, as long as the
+// reservation hasn't come due by t; after that the caller is assumed to have
+// acted on it and nothing happens. Like golang.org/x/time/rate, capacity that
+// reservations made after this one already count on stays reserved, so they
+// don't end up sharing it with the next one. It is safe to call more than once.
+func (r *Reservation) CancelAt(t time.Time) {
+	if !r.ok || r.timeToAct.Before(t) {
+		return
+	}
+
+	r.once.Do(func() { r.cancel(t) })
+}
+
+// Refund gives the reserved capacity back to the key even after the
+// reservation came due, for requests that turned out not to count or were
+// never acted on. It does nothing after Cancel, or if called again.
+func (r *Reservation) Refund() {
+	if !r.ok {
+		return
+	}
+
+	r.once.Do(r.refund)
+}
+
+// Decision reports the key's state at the time the reservation was made.
+// Allowed is only true if the reservation could be acted on right away, a
+// reservation that is not OK carries the RetryAfter of a denied request.
+func (r *Reservation) Decision() Decision {
+	return r.decision
+}
@@ -0,0 +1,80 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+)
+
+// reservingLimiters are the limiters that reserve capacity ahead, each allowing
+// one request at once and one more every hour
+var reservingLimiters = []struct {
+	name string
+	cfg  map[string]any
+}{
+	{"token_bucket", map[string]any{"capacity": 1, "refill_rate": 1 / 3600.0, "tokens": 1}},
+	{"gcra", map[string]any{"rate": 1, "period": time.Hour, "burst": 1}},
+	{"leaky_bucket", map[string]any{"leak_rate": 1 / 3600.0, "queue_size": 5}},
+}
+
+func TestReservation_CancelAfterActing(t *testing.T) {
+	for _, tt := range reservingLimiters {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRegistryLimiter(t, tt.name, tt.cfg)
+
+			r := l.Reserve("key", 1)
+			if !r.OK() || r.Delay() != 0 {
+				t.Fatalf("expected the reservation to be due right away")
+			}
+			// acted on, then cancelled too late
+			time.Sleep(time.Millisecond)
+			r.Cancel()
+			if l.Allow("key") {
+				t.Error("expected cancel to do nothing once the reservation came due")
+			}
+		})
+	}
+}
+
+func TestReservation_CancelKeepsLaterReservations(t *testing.T) {
+	for _, tt := range reservingLimiters {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRegistryLimiter(t, tt.name, tt.cfg)
+
+			l.Reserve("key", 1)
+			r2 := l.Reserve("key", 1)
+			r3 := l.Reserve("key", 1)
+			r2.Cancel()
+
+			// r3 was timed after r2, the next reservation has to come after r3
+			r4 := l.Reserve("key", 1)
+			if r4.Delay() <= r3.Delay() {
+				t.Errorf("expected the next reservation after r3 at %v, got %v", r3.Delay(), r4.Delay())
+			}
+
+			// cancelling the latest reservation gives its slot back
+			r4.Cancel()
+			if r5 := l.Reserve("key", 1); r5.Delay() > r3.Delay()+time.Hour+time.Second {
+				t.Errorf("expected the cancelled slot to be reused, got %v", r5.Delay())
+			}
+		})
+	}
+}
+
+func TestReservation_Refund(t *testing.T) {
+	for _, tt := range reservingLimiters {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newRegistryLimiter(t, tt.name, tt.cfg)
+
+			r := l.Reserve("key", 1)
+			time.Sleep(time.Millisecond)
+			r.Refund()
+			r.Refund()
+			if !l.Allow("key") {
+				t.Error("expected refund to give the capacity back after the reservation came due")
+			}
+			if l.Allow("key") {
+				t.Error("expected refund to give the capacity back only once")
+			}
+		})
+	}
+}
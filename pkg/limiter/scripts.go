@@ -0,0 +1,591 @@
+package limiter
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+// The limiters run their check-and-consume as one of the scripts below if
+// their bucket is a bucket.ScriptRunner, like bucket.RedisBucket. The scripts
+// keep the key's state in a hash and time everything by the server's clock in
+// microseconds. ARGV[1] is the operation:
+//
+//   - reserve: reserve ARGV[2] tokens if they can be acted on within ARGV[3]
+//     microseconds, -1 meaning any time
+//   - charge: deduct ARGV[2] tokens, even if that puts the key into debt
+//   - cancel: give back the ARGV[2] tokens of the reservation identified by
+//     ARGV[3], less what later reservations depend on
+//   - refund: give back all ARGV[2] tokens of the reservation ARGV[3]
+//
+// The limiter's parameters follow from ARGV[4] on. Every script replies with
+// whether the tokens were reserved (or, for charge, whether the key can make
+// another request right away), the requests the key has left, the time until
+// it is back to its full limit, the time until the reservation can be acted
+// on (or the request retried) and a value identifying the reservation.
+const scriptPrelude = `local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000000 + tonumber(clock[2])
+local op, n, arg = ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3])
+
+-- save stores the given fields of the key's state until expiry, or deletes
+-- the key if it would behave like a missing one by then
+local function save(expiry, ...)
+  if expiry <= now then
+    redis.call('DEL', KEYS[1])
+    return
+  end
+  redis.call('HSET', KEYS[1], ...)
+  redis.call('PEXPIRE', KEYS[1], math.ceil((expiry - now) / 1000))
+end
+
+-- fits returns whether wait is within the maximum wait of a reservation
+local function fits(wait)
+  return arg < 0 or wait <= arg
+end
+
+local function reply(ok, remaining, reset, wait, id)
+  return {ok and 1 or 0, remaining, reset, wait, id or 0}
+end
+`
+
+// ARGV[4] is the capacity, ARGV[5] the refill rate in tokens per second and
+// ARGV[6] the tokens a new bucket starts with.
+var tokenBucketScript = bucket.NewScript(scriptPrelude + `
+local capacity, rate, initial = tonumber(ARGV[4]), tonumber(ARGV[5]), tonumber(ARGV[6])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'last_refill', 'last_event')
+local tokens, lastRefill, lastEvent = tonumber(state[1]), tonumber(state[2]), tonumber(state[3]) or 0
+
+local function refill()
+  if tokens == nil then
+    tokens, lastRefill = initial, now
+    return
+  end
+  local added = math.floor((now - lastRefill) / 1000000 * rate)
+  if added > 0 then
+    tokens = math.min(capacity, tokens + added)
+    lastRefill = now
+  end
+end
+
+-- refilledAt returns the time the bucket holds count tokens
+local function refilledAt(count)
+  local missing = count - tokens
+  if missing <= 0 then
+    return lastRefill
+  end
+  return lastRefill + math.ceil(missing / rate * 1000000)
+end
+
+local function store()
+  save(refilledAt(math.min(initial, capacity)), 'tokens', tokens, 'last_refill', lastRefill, 'last_event', lastEvent)
+end
+
+if op == 'reserve' then
+  refill()
+  local remaining, reset = math.max(tokens, 0), refilledAt(capacity) - now
+  if n > capacity then
+    store()
+    return reply(false, remaining, reset, 0)
+  end
+
+  local timeToAct = now
+  if tokens < n then
+    timeToAct = refilledAt(n)
+  end
+  if not fits(timeToAct - now) then
+    store()
+    return reply(false, remaining, reset, timeToAct - now)
+  end
+
+  tokens = tokens - n
+  lastEvent = math.max(lastEvent, timeToAct)
+  store()
+  return reply(true, math.max(tokens, 0), refilledAt(capacity) - now, timeToAct - now, timeToAct)
+elseif op == 'charge' then
+  refill()
+  tokens = tokens - n
+  local wait = 0
+  if tokens < 1 then
+    wait = refilledAt(1) - now
+  end
+  store()
+  return reply(tokens >= 1, math.max(tokens, 0), refilledAt(capacity) - now, wait)
+elseif tokens == nil then
+  return reply(false, 0, 0, 0)
+elseif op == 'cancel' then
+  -- the reservations after this one were timed on the bucket owing its
+  -- tokens too, those tokens stay theirs
+  local reservedAfter = (lastEvent - arg) / 1000000 * rate
+  if reservedAfter < 0 then
+    reservedAfter = -math.floor(-reservedAfter + 0.5)
+  else
+    reservedAfter = math.floor(reservedAfter + 0.5)
+  end
+  local restore = n - reservedAfter
+  if restore > 0 then
+    refill()
+    tokens = math.min(capacity, tokens + restore)
+    -- the latest reservation is gone, the one before it is the latest now
+    if lastEvent == arg then
+      local prev = arg - math.ceil(n / rate * 1000000)
+      if prev >= now then
+        lastEvent = prev
+      end
+    end
+    store()
+  end
+elseif op == 'refund' then
+  tokens = math.min(capacity, tokens + n)
+  store()
+end
+return reply(false, 0, 0, 0)
+`)
+
+// ARGV[4] is the number of tokens per window and ARGV[5] the window size in
+// microseconds, whole seconds are used.
+var fixedWindowScript = bucket.NewScript(scriptPrelude + `
+local limit, size = tonumber(ARGV[4]), math.max(math.floor(tonumber(ARGV[5]) / 1000000), 1)
+local window = math.floor(math.floor(now / 1000000) / size)
+local windowEnd = (window + 1) * size * 1000000
+local state = redis.call('HMGET', KEYS[1], 'window', 'tokens')
+local current, tokens = tonumber(state[1]), tonumber(state[2])
+
+-- roll starts over if we moved into a new window
+local function roll()
+  if current ~= window then
+    current, tokens = window, limit
+  end
+end
+
+local function store()
+  save((current + 1) * size * 1000000, 'window', current, 'tokens', tokens)
+end
+
+if op == 'reserve' then
+  roll()
+  if tokens < n then
+    local wait = 0
+    if n <= limit then
+      wait = windowEnd - now
+    end
+    store()
+    return reply(false, math.max(tokens, 0), windowEnd - now, wait)
+  end
+
+  tokens = tokens - n
+  store()
+  return reply(true, tokens, windowEnd - now, 0, window)
+elseif op == 'charge' then
+  roll()
+  tokens = tokens - n
+  local wait = 0
+  if tokens < 1 then
+    wait = windowEnd - now
+  end
+  store()
+  return reply(tokens >= 1, math.max(tokens, 0), windowEnd - now, wait)
+elseif current == arg then
+  -- tokens only go back to the window they were taken from
+  tokens = math.min(limit, tokens + n)
+  store()
+end
+return reply(false, 0, 0, 0)
+`)
+
+// ARGV[4] is the number of tokens per window and ARGV[5] the window length in
+// microseconds.
+var slidingWindowCounterScript = bucket.NewScript(scriptPrelude + `
+local limit, length = tonumber(ARGV[4]), tonumber(ARGV[5])
+local window = math.floor(now / length)
+local windowStart = window * length
+local state = redis.call('HMGET', KEYS[1], 'window', 'current', 'previous')
+local current, count, previous = tonumber(state[1]), tonumber(state[2]), tonumber(state[3])
+
+-- roll rolls the counters over if we moved into a new window, the previous
+-- count only carries over if the stored window is the one right before
+local function roll()
+  if current == window then
+    return
+  end
+  if current == window - 1 then
+    previous = count
+  else
+    previous = 0
+  end
+  current, count = window, 0
+end
+
+-- weighted returns the requests counted in the sliding window ending now
+local function weighted()
+  return previous * (1 - (now % length) / length) + count
+end
+
+-- resetAt returns the time nothing counts against the key anymore
+local function resetAt()
+  if count > 0 then
+    return windowStart + 2 * length
+  end
+  if previous > 0 then
+    return windowStart + length
+  end
+  return now
+end
+
+-- retryAt returns the first time the weighted count leaves room for k more
+-- requests, assuming no more requests are allowed in between
+local function retryAt(k)
+  local free = limit - count - k
+  if free >= 0 and previous > 0 then
+    return windowStart + math.ceil((1 - free / previous) * length)
+  end
+  return windowStart + length + math.ceil((1 - (limit - k) / count) * length)
+end
+
+-- the current window keeps counting until the end of the next one
+local function store()
+  local expiry = (current + 1) * length
+  if count > 0 then
+    expiry = expiry + length
+  end
+  save(expiry, 'window', current, 'current', count, 'previous', previous)
+end
+
+if op == 'reserve' then
+  roll()
+  local used = weighted()
+  local ok = used + n <= limit
+  if ok then
+    count = count + n
+    used = used + n
+  end
+
+  local wait = 0
+  if not ok and n <= limit then
+    wait = retryAt(n) - now
+  end
+  store()
+  return reply(ok, math.max(math.floor(limit - used), 0), resetAt() - now, wait, window)
+elseif op == 'charge' then
+  roll()
+  count = count + n
+  local used = weighted()
+  local wait = 0
+  if used + 1 > limit then
+    wait = retryAt(1) - now
+  end
+  store()
+  return reply(used + 1 <= limit, math.max(math.floor(limit - used), 0), resetAt() - now, wait)
+elseif current ~= nil then
+  -- requests are taken back off the window they were counted in, which may
+  -- have become the previous window since
+  if arg == current then
+    count = math.max(count - n, 0)
+  elseif arg == current - 1 then
+    previous = math.max(previous - n, 0)
+  else
+    return reply(false, 0, 0, 0)
+  end
+  store()
+end
+return reply(false, 0, 0, 0)
+`)
+
+// ARGV[4] is the capacity and ARGV[5] the window in microseconds. The log is
+// stored as comma separated timestamp:cost entries, oldest first.
+var slidingWindowLogScript = bucket.NewScript(scriptPrelude + `
+local capacity, window = tonumber(ARGV[4]), tonumber(ARGV[5])
+local log, used = {}, 0
+for timestamp, cost in string.gmatch(redis.call('HGET', KEYS[1], 'log') or '', '(%d+):(%d+)') do
+  timestamp, cost = tonumber(timestamp), tonumber(cost)
+  -- drop the entries that are no longer in the window
+  if timestamp + window > now then
+    table.insert(log, {timestamp, cost})
+    used = used + cost
+  end
+end
+
+-- insert adds an entry keeping the log ordered by timestamp
+local function insert(timestamp, cost)
+  local i = #log + 1
+  while i > 1 and log[i - 1][1] > timestamp do
+    i = i - 1
+  end
+  table.insert(log, i, {timestamp, cost})
+end
+
+-- fitsAt returns the first time an entry weighing k fits in the log, total
+-- being the log's weight
+local function fitsAt(total, k)
+  local at = now
+  for _, entry in ipairs(log) do
+    if total + k <= capacity then
+      break
+    end
+    total = total - entry[2]
+    at = entry[1] + window
+  end
+  return at
+end
+
+-- resetAt returns the time the newest entry expires
+local function resetAt()
+  if #log == 0 then
+    return now
+  end
+  return log[#log][1] + window
+end
+
+local function store()
+  local entries = {}
+  for i, entry in ipairs(log) do
+    entries[i] = string.format('%d:%d', entry[1], entry[2])
+  end
+  save(resetAt(), 'log', table.concat(entries, ','))
+end
+
+if op == 'reserve' then
+  local remaining = math.max(capacity - used, 0)
+  if n > capacity then
+    store()
+    return reply(false, remaining, resetAt() - now, 0)
+  end
+
+  local timeToAct = fitsAt(used, n)
+  if not fits(timeToAct - now) then
+    store()
+    return reply(false, remaining, resetAt() - now, timeToAct - now)
+  end
+
+  insert(timeToAct, n)
+  store()
+  return reply(true, math.max(remaining - n, 0), resetAt() - now, timeToAct - now, timeToAct)
+elseif op == 'charge' then
+  used = used + n
+  insert(now, n)
+  local wait = fitsAt(used, 1) - now
+  store()
+  return reply(wait <= 0, math.max(capacity - used, 0), resetAt() - now, math.max(wait, 0))
+end
+
+-- cancel and refund remove the reserved entry
+for i, entry in ipairs(log) do
+  if entry[1] == arg and entry[2] == n then
+    table.remove(log, i)
+    store()
+    break
+  end
+end
+return reply(false, 0, 0, 0)
+`)
+
+// ARGV[4] is the emission interval in microseconds and ARGV[5] the burst.
+var gcraScript = bucket.NewScript(scriptPrelude + `
+local interval, burst = tonumber(ARGV[4]), tonumber(ARGV[5])
+local tolerance = interval * burst
+local tat = tonumber(redis.call('HGET', KEYS[1], 'tat'))
+
+-- remaining returns how many more requests fit in the burst tolerance
+local function remaining(at)
+  return math.max(math.floor((tolerance - (at - now)) / interval), 0)
+end
+
+if op == 'reserve' or op == 'charge' then
+  -- a TAT in the past means the key has been idle, start from now
+  local start = math.max(tat or now, now)
+  if op == 'charge' then
+    tat = start + n * interval
+    -- the next request conforms once the TAT is back within the burst
+    -- tolerance of now
+    local wait = math.max(tat + interval - tolerance - now, 0)
+    save(tat, 'tat', tat)
+    return reply(wait == 0, remaining(tat), tat - now, wait)
+  end
+
+  if n > burst then
+    return reply(false, remaining(start), start - now, 0)
+  end
+  local newTAT = start + n * interval
+  local wait = math.max(newTAT - tolerance, now) - now
+  if not fits(wait) then
+    return reply(false, remaining(start), start - now, wait)
+  end
+
+  tat = newTAT
+  save(tat, 'tat', tat)
+  return reply(true, remaining(tat), tat - now, wait, tat)
+elseif tat ~= nil then
+  -- cancel keeps the intervals reserved after the reservation that pushed
+  -- the TAT to arg, those slots stay theirs
+  local restore = n * interval
+  if op == 'cancel' then
+    restore = restore - (tat - arg)
+  end
+  if restore > 0 then
+    tat = tat - restore
+    save(tat, 'tat', tat)
+  end
+end
+return reply(false, 0, 0, 0)
+`)
+
+// ARGV[4] is the drain interval in microseconds and ARGV[5] the queue size.
+var leakyBucketScript = bucket.NewScript(scriptPrelude + `
+local interval, queueSize = tonumber(ARGV[4]), tonumber(ARGV[5])
+local lastRelease = tonumber(redis.call('HGET', KEYS[1], 'last_release'))
+
+-- remaining returns the release slots still free if the key's last request
+-- is released at last
+local function remaining(last)
+  local busy = last + interval - now
+  if busy <= 0 then
+    return queueSize + 1
+  end
+  return math.max(queueSize + 1 - math.ceil(busy / interval), 0)
+end
+
+local function store()
+  save(lastRelease + interval, 'last_release', lastRelease)
+end
+
+if op == 'reserve' or op == 'charge' then
+  -- the next slot is one interval after the last release, or now if the
+  -- queue has drained
+  local slot = now
+  if lastRelease ~= nil and lastRelease + interval > now then
+    slot = lastRelease + interval
+  end
+  local wait = slot - now
+  if op == 'reserve' and not fits(wait) then
+    return reply(false, remaining(slot - interval), wait, wait)
+  end
+
+  lastRelease = slot + (n - 1) * interval
+  store()
+  if op == 'charge' then
+    -- the queue is free again once the charged intervals have drained
+    local busy = wait + n * interval
+    return reply(false, remaining(lastRelease), busy, busy)
+  end
+  return reply(true, remaining(lastRelease), lastRelease + interval - now, wait, lastRelease)
+elseif lastRelease ~= nil then
+  -- cancel keeps the intervals reserved after the reservation whose last
+  -- one is released at arg, those slots stay theirs
+  local restore = n * interval
+  if op == 'cancel' then
+    restore = restore - (lastRelease - arg)
+  end
+  if restore > 0 then
+    lastRelease = lastRelease - restore
+    store()
+  end
+end
+return reply(false, 0, 0, 0)
+`)
+
+var errScriptReply = errors.New("Unexpected script reply")
+
+// scriptReply is the reply of a limiter's script, see scriptPrelude
+type scriptReply struct {
+	ok        bool
+	remaining int
+	reset     time.Duration
+	wait      time.Duration
+	id        int64
+}
+
+// scriptRunner returns b as a bucket.ScriptRunner, nil if it can't run scripts
+func scriptRunner(b any) bucket.ScriptRunner {
+	runner, _ := b.(bucket.ScriptRunner)
+	return runner
+}
+
+// runScript runs op of a limiter's script on key for n tokens. arg is the
+// maximum wait of a reservation or the id of the reservation to give back.
+func runScript(runner bucket.ScriptRunner, script *bucket.Script, key string, op string, n int, arg int64, params ...any) (scriptReply, error) {
+	args := []string{op, strconv.Itoa(n), strconv.FormatInt(arg, 10)}
+	for _, param := range params {
+		switch v := param.(type) {
+		case int:
+			args = append(args, strconv.Itoa(v))
+		case float64:
+			args = append(args, strconv.FormatFloat(v, 'g', -1, 64))
+		case time.Duration:
+			args = append(args, strconv.FormatInt(scriptMicros(v), 10))
+		}
+	}
+
+	values, err := runner.RunScript(script, key, args...)
+	if err != nil {
+		return scriptReply{}, err
+	}
+	if len(values) != 5 {
+		return scriptReply{}, errScriptReply
+	}
+
+	return scriptReply{
+		ok:        values[0] == 1,
+		remaining: int(values[1]),
+		reset:     time.Duration(values[2]) * time.Microsecond,
+		wait:      time.Duration(values[3]) * time.Microsecond,
+		id:        values[4],
+	}, nil
+}
+
+// scriptMicros converts d to the microseconds the scripts count in, at least
+// one for a positive d and -1 for InfDuration. Rounding down keeps multiples
+// of a duration within the same multiple of a longer one.
+func scriptMicros(d time.Duration) int64 {
+	switch {
+	case d == InfDuration:
+		return -1
+	case d <= 0:
+		return 0
+	}
+	return max(d.Microseconds(), 1)
+}
+
+// decision returns the key's state reported by the script as a decision
+// taken at now, limits carrying the fields that don't depend on the state.
+func (r scriptReply) decision(limits Decision, now time.Time) Decision {
+	decision := limits
+	decision.Allowed = r.ok && r.wait == 0
+	decision.Remaining = r.remaining
+	decision.ResetAt = now.Add(r.reset)
+	decision.RetryAfter = r.wait
+	return decision
+}
+
+// reserveByScript reserves n tokens for key through a limiter's script, as
+// long as they can be acted on within maxWait. now is the limiter's clock, the
+// script's replies being relative to the server's. The reservation runs the
+// script again to give the tokens back.
+func reserveByScript(runner bucket.ScriptRunner, script *bucket.Script, key string, n int, now time.Time, maxWait time.Duration, limits Decision, params ...any) *Reservation {
+	reply, err := runScript(runner, script, key, "reserve", n, scriptMicros(maxWait), params...)
+	if err != nil {
+		// fail closed if the script can't be run
+		return DeniedReservation(limits)
+	}
+
+	decision := reply.decision(limits, now)
+	if !reply.ok {
+		return DeniedReservation(decision)
+	}
+
+	return newReservation(decision, now.Add(reply.wait), func(time.Time) {
+		runScript(runner, script, key, "cancel", n, reply.id, params...)
+	}, func() {
+		runScript(runner, script, key, "refund", n, reply.id, params...)
+	})
+}
+
+// chargeByScript deducts n tokens from key through a limiter's script.
+func chargeByScript(runner bucket.ScriptRunner, script *bucket.Script, key string, n int, now time.Time, limits Decision, params ...any) Decision {
+	reply, err := runScript(runner, script, key, "charge", n, 0, params...)
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return limits
+	}
+	return reply.decision(limits, now)
+}
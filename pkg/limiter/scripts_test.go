@@ -0,0 +1,416 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/internal/redistest"
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+// scriptCalls records the scripts a scriptedBucket ran and answers them with
+// reply, or err
+type scriptCalls struct {
+	scripts []*bucket.Script
+	args    [][]string
+	reply   []int64
+	err     error
+}
+
+func (c *scriptCalls) last() []string {
+	return c.args[len(c.args)-1]
+}
+
+// scriptedBucket is a bucket whose store runs the limiters' scripts. Its
+// Bucket methods are nil and panic, the limiters must not use them.
+type scriptedBucket[T bucket.AllowedTypes] struct {
+	bucket.Bucket[T]
+	calls *scriptCalls
+}
+
+func (b scriptedBucket[T]) RunScript(script *bucket.Script, key string, args ...string) ([]int64, error) {
+	b.calls.scripts = append(b.calls.scripts, script)
+	b.calls.args = append(b.calls.args, append([]string{key}, args...))
+	return b.calls.reply, b.calls.err
+}
+
+func TestLimiters_RunScripts(t *testing.T) {
+	tests := []struct {
+		name   string
+		script *bucket.Script
+		new    func(calls *scriptCalls) Limiter
+	}{
+		{"token_bucket", tokenBucketScript, func(calls *scriptCalls) Limiter {
+			return NewTokenBucketLimiter(scriptedBucket[bucket.TokenBucketType]{calls: calls}, BucketConfig{Capacity: 10, RefillRate: 1, Tokens: 10})
+		}},
+		{"fixed_window", fixedWindowScript, func(calls *scriptCalls) Limiter {
+			return NewFixedWindowLimiter(scriptedBucket[bucket.FixedWindowBucketType]{calls: calls}, FixedWindowConfig{WindowDuration: time.Minute, WindowTokens: 10})
+		}},
+		{"sliding_window_log", slidingWindowLogScript, func(calls *scriptCalls) Limiter {
+			return NewSlidingWindowLogLimiter(scriptedBucket[bucket.SlidingWindowLogBucketType]{calls: calls}, SlidingWindowLogConfig{Capacity: 10})
+		}},
+		{"sliding_window_counter", slidingWindowCounterScript, func(calls *scriptCalls) Limiter {
+			return NewSlidingWindowCounterLimiter(scriptedBucket[bucket.SlidingWindowCounterBucketType]{calls: calls}, SlidingWindowCounterConfig{WindowDuration: time.Minute, WindowTokens: 10})
+		}},
+		{"gcra", gcraScript, func(calls *scriptCalls) Limiter {
+			return NewGCRALimiter(scriptedBucket[bucket.GCRABucketType]{calls: calls}, GCRAConfig{Rate: 10, Period: time.Minute})
+		}},
+		{"leaky_bucket", leakyBucketScript, func(calls *scriptCalls) Limiter {
+			return NewLeakyBucketLimiter(scriptedBucket[bucket.LeakyBucketType]{calls: calls}, LeakyBucketConfig{LeakRate: 1, QueueSize: 9})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &scriptCalls{}
+			l := tt.new(calls)
+
+			// reserved, 4 left, full again in a minute
+			calls.reply = []int64{1, 4, time.Minute.Microseconds(), 0, 42}
+			decision := l.DecideN("key", 2)
+			if !decision.Allowed || decision.Remaining != 4 || decision.Limit != 10 {
+				t.Errorf("expected the script's decision, got %+v", decision)
+			}
+			if reset := time.Until(decision.ResetAt); reset < 59*time.Second || reset > time.Minute {
+				t.Errorf("expected the reset a minute from now, got %v", reset)
+			}
+			if calls.scripts[0] != tt.script {
+				t.Errorf("expected the %s script to run", tt.name)
+			}
+			if args := calls.last(); args[0] != "key" || args[1] != "reserve" || args[2] != "2" || args[3] != "0" {
+				t.Errorf("expected a reserve of 2 tokens without waiting, got %v", args)
+			}
+
+			// reserved, to be acted on in a second
+			calls.reply = []int64{1, 0, time.Minute.Microseconds(), time.Second.Microseconds(), 42}
+			r := l.Reserve("key", 1)
+			if !r.OK() || r.Delay() < 990*time.Millisecond || r.Decision().Allowed {
+				t.Errorf("expected the reservation to be due in a second, got a delay of %v", r.Delay())
+			}
+			r.Cancel()
+			if args := calls.last(); args[1] != "cancel" || args[2] != "1" || args[3] != "42" {
+				t.Errorf("expected the reservation to be cancelled by its id, got %v", args)
+			}
+
+			r = l.Reserve("key", 3)
+			r.Refund()
+			if args := calls.last(); args[1] != "refund" || args[2] != "3" || args[3] != "42" {
+				t.Errorf("expected the reservation to be refunded by its id, got %v", args)
+			}
+
+			// denied, retry in a second
+			calls.reply = []int64{0, 0, time.Minute.Microseconds(), time.Second.Microseconds(), 0}
+			if r := l.Reserve("key", 1); r.OK() || r.Decision().RetryAfter != time.Second {
+				t.Errorf("expected a denied reservation, got %+v", r.Decision())
+			}
+
+			decision = l.(Charger).Charge("key", 5)
+			if decision.Allowed || decision.RetryAfter != time.Second {
+				t.Errorf("expected the charged key to be denied, got %+v", decision)
+			}
+			if args := calls.last(); args[1] != "charge" || args[2] != "5" {
+				t.Errorf("expected a charge of 5 tokens, got %v", args)
+			}
+
+			// fail closed
+			calls.reply, calls.err = nil, errors.New("unreachable")
+			if decision := l.Decide("key"); decision.Allowed || decision.Limit != 10 {
+				t.Errorf("expected a denial when the script can't run, got %+v", decision)
+			}
+		})
+	}
+}
+
+// stepClock is the clock a scenario's limiters and the stand-in server share,
+// moved forward by the scenario's steps only
+type stepClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *stepClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *stepClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// setNow replaces the clock of one of the built-in limiters
+func setNow(l Limiter, now func() time.Time) {
+	switch l := l.(type) {
+	case *TokenBucketLimiter:
+		l.now = now
+	case *FixedWindowLimiter:
+		l.now = now
+	case *SlidingWindowCounterLimiter:
+		l.now = now
+	case *SlidingWindowLogLimiter:
+		l.now = now
+	case *GCRALimiter:
+		l.now = now
+	case *LeakyBucketLimiter:
+		l.now = now
+	}
+}
+
+// scriptStep is one operation of a scenario, run against both the in-memory
+// and the scripted limiter after moving the clock forward by advance.
+type scriptStep struct {
+	desc    string
+	advance time.Duration
+	run     func(l Limiter, clock *stepClock, reservations map[string]*Reservation) Decision
+}
+
+func decideStep(n int) scriptStep {
+	return scriptStep{desc: fmt.Sprintf("DecideN(%d)", n), run: func(l Limiter, _ *stepClock, _ map[string]*Reservation) Decision {
+		return l.DecideN("key", n)
+	}}
+}
+
+// reserveStep reports the reservation's delay as RetryAfter, and a denied
+// reservation with a negative Window
+func reserveStep(name string, n int) scriptStep {
+	return scriptStep{desc: fmt.Sprintf("Reserve(%d) as %s", n, name), run: func(l Limiter, clock *stepClock, reservations map[string]*Reservation) Decision {
+		r := l.Reserve("key", n)
+		reservations[name] = r
+		decision := r.Decision()
+		if !r.OK() {
+			decision.Window = -1
+			return decision
+		}
+		decision.RetryAfter = r.DelayFrom(clock.Now())
+		return decision
+	}}
+}
+
+func cancelStep(name string) scriptStep {
+	return scriptStep{desc: "Cancel " + name, run: func(l Limiter, clock *stepClock, reservations map[string]*Reservation) Decision {
+		reservations[name].CancelAt(clock.Now())
+		return Decision{}
+	}}
+}
+
+func refundStep(name string) scriptStep {
+	return scriptStep{desc: "Refund " + name, run: func(l Limiter, _ *stepClock, reservations map[string]*Reservation) Decision {
+		reservations[name].Refund()
+		return Decision{}
+	}}
+}
+
+func chargeStep(n int) scriptStep {
+	return scriptStep{desc: fmt.Sprintf("Charge(%d)", n), run: func(l Limiter, _ *stepClock, _ map[string]*Reservation) Decision {
+		return l.(Charger).Charge("key", n)
+	}}
+}
+
+func after(d time.Duration, step scriptStep) scriptStep {
+	step.advance = d
+	step.desc = fmt.Sprintf("%s after %v", step.desc, d)
+	return step
+}
+
+// clockedBucket drops the states that expired on now, like the stand-in
+// server drops keys past their PEXPIRE, as the in-memory bucket only expires
+// them on the wall clock
+type clockedBucket[T bucket.AllowedTypes] struct {
+	bucket.Bucket[T]
+	now func() time.Time
+}
+
+func (b clockedBucket[T]) expired(state *T) bool {
+	expirer, ok := any(state).(bucket.Expirer)
+	return ok && !expirer.ExpiresAt().IsZero() && !expirer.ExpiresAt().After(b.now())
+}
+
+func (b clockedBucket[T]) Get(key string) *T {
+	if state := b.Bucket.Get(key); state != nil && !b.expired(state) {
+		return state
+	}
+	return nil
+}
+
+func (b clockedBucket[T]) Update(key string, fn bucket.UpdateFunc[T]) error {
+	return b.Bucket.Update(key, func(state *T) (*T, error) {
+		if state != nil && b.expired(state) {
+			state = nil
+		}
+		return fn(state)
+	})
+}
+
+// scriptPair returns the limiter built by newLimiter over an in-memory bucket
+// and over a RedisBucket on the stand-in at addr, which runs its scripts, both
+// on the clock now
+func scriptPair[T bucket.AllowedTypes](t *testing.T, addr string, now func() time.Time, newLimiter func(bucket.Bucket[T]) Limiter) (Limiter, Limiter) {
+	inMemory := bucket.NewInMemoryBucket[T]()
+	redis := bucket.NewRedisBucket[T](addr)
+	t.Cleanup(func() {
+		inMemory.Close()
+		redis.Close()
+	})
+
+	memLimiter, scriptLimiter := newLimiter(clockedBucket[T]{Bucket: inMemory, now: now}), newLimiter(redis)
+	setNow(memLimiter, now)
+	setNow(scriptLimiter, now)
+	return memLimiter, scriptLimiter
+}
+
+// TestLimiters_ScriptsMatchInMemory runs each scenario against a limiter over
+// an in-memory bucket and the same limiter over a RedisBucket, whose Lua
+// scripts run on the stand-in server, and checks every step decides the same.
+// Both run on a clock starting at a full hour, so windows start with it.
+func TestLimiters_ScriptsMatchInMemory(t *testing.T) {
+	tests := []struct {
+		name  string
+		pair  func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter)
+		steps []scriptStep
+	}{
+		{
+			name: "token_bucket",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.TokenBucketType]) Limiter {
+					return NewTokenBucketLimiter(b, BucketConfig{Capacity: 5, RefillRate: 1, Tokens: 5})
+				})
+			},
+			steps: []scriptStep{
+				decideStep(1), decideStep(1), decideStep(1), decideStep(3), reserveStep("a", 3), reserveStep("b", 2),
+				cancelStep("a"), decideStep(1), refundStep("b"), decideStep(1), chargeStep(10), decideStep(1),
+				decideStep(6), reserveStep("c", 6), after(2500*time.Millisecond, decideStep(1)), after(10*time.Second, decideStep(5)),
+				reserveStep("d", 2), reserveStep("e", 2), cancelStep("e"), cancelStep("d"), decideStep(1),
+				after(time.Second, reserveStep("f", 1)), reserveStep("g", 2), cancelStep("f"), decideStep(1),
+				after(10*time.Second, decideStep(5)), after(1500*time.Millisecond, decideStep(2)),
+				after(200*time.Millisecond, decideStep(1)), after(10*time.Second, chargeStep(4)), chargeStep(1),
+			},
+		},
+		{
+			name: "token_bucket_fractional_refill",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.TokenBucketType]) Limiter {
+					return NewTokenBucketLimiter(b, BucketConfig{Capacity: 3, RefillRate: 2.5, Tokens: 3})
+				})
+			},
+			steps: []scriptStep{
+				decideStep(1), decideStep(1), decideStep(1), decideStep(1), after(300*time.Millisecond, decideStep(1)),
+				after(150*time.Millisecond, decideStep(1)), reserveStep("a", 1), reserveStep("b", 1), cancelStep("b"),
+				reserveStep("c", 1), after(time.Second, cancelStep("c")), decideStep(1),
+			},
+		},
+		{
+			// a new key starts below capacity, an idle key must not refill past it
+			name: "token_bucket_below_capacity",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.TokenBucketType]) Limiter {
+					return NewTokenBucketLimiter(b, BucketConfig{Capacity: 5, RefillRate: 1, Tokens: 2})
+				})
+			},
+			steps: []scriptStep{
+				decideStep(1), decideStep(1), decideStep(1), after(time.Second, decideStep(1)), after(10*time.Second, decideStep(3)),
+				decideStep(2), reserveStep("a", 1), refundStep("a"), chargeStep(1), after(time.Second, chargeStep(1)),
+				after(500*time.Millisecond, decideStep(1)), after(10*time.Second, decideStep(2)), decideStep(1),
+			},
+		},
+		{
+			name: "fixed_window",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.FixedWindowBucketType]) Limiter {
+					return NewFixedWindowLimiter(b, FixedWindowConfig{WindowDuration: time.Minute, WindowTokens: 5, WindowSize: 1})
+				})
+			},
+			steps: []scriptStep{
+				decideStep(1), decideStep(1), decideStep(4), reserveStep("a", 3), decideStep(1), refundStep("a"), decideStep(1),
+				reserveStep("b", 2), cancelStep("b"), decideStep(1), chargeStep(10), decideStep(1), decideStep(6),
+				after(30*time.Second, decideStep(1)), after(30*time.Second, decideStep(1)), reserveStep("c", 4),
+				after(2*time.Minute, decideStep(2)), chargeStep(2), decideStep(5), chargeStep(1),
+			},
+		},
+		{
+			name: "sliding_window_counter",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.SlidingWindowCounterBucketType]) Limiter {
+					return NewSlidingWindowCounterLimiter(b, SlidingWindowCounterConfig{WindowDuration: time.Minute, WindowTokens: 5, WindowSize: 1})
+				})
+			},
+			steps: []scriptStep{
+				chargeStep(4), reserveStep("a", 1), decideStep(4), after(time.Minute, decideStep(5)), refundStep("a"), decideStep(1), reserveStep("b", 1), cancelStep("b"), after(20*time.Second, decideStep(1)),
+				after(50*time.Second, decideStep(1)), decideStep(2), chargeStep(10), decideStep(1), after(time.Minute, decideStep(1)),
+				after(3*time.Minute, decideStep(6)), reserveStep("c", 2), refundStep("c"), decideStep(5),
+			},
+		},
+		{
+			name: "sliding_window_log",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.SlidingWindowLogBucketType]) Limiter {
+					return NewSlidingWindowLogLimiter(b, SlidingWindowLogConfig{WindowSize: 1, Capacity: 5, WindowDuration: time.Minute})
+				})
+			},
+			steps: []scriptStep{
+				chargeStep(1), after(10*time.Second, decideStep(3)), decideStep(2), reserveStep("a", 3), reserveStep("b", 1),
+				cancelStep("a"), decideStep(1), refundStep("b"), decideStep(1), chargeStep(4), decideStep(1), decideStep(6),
+				reserveStep("c", 6), after(50*time.Second, decideStep(1)), after(time.Minute, decideStep(2)),
+				after(3*time.Minute, decideStep(5)), reserveStep("d", 2), chargeStep(1), decideStep(1),
+				after(time.Minute, decideStep(3)), after(2*time.Minute, reserveStep("e", 2)), after(10*time.Second, chargeStep(1)),
+				after(50*time.Second, decideStep(3)),
+			},
+		},
+		{
+			name: "gcra",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.GCRABucketType]) Limiter {
+					return NewGCRALimiter(b, GCRAConfig{Rate: 5, Period: time.Minute, Burst: 3})
+				})
+			},
+			steps: []scriptStep{
+				decideStep(1), decideStep(1), decideStep(2), reserveStep("a", 2), reserveStep("b", 1), reserveStep("c", 1),
+				cancelStep("b"), reserveStep("d", 1), refundStep("c"), decideStep(1), chargeStep(3), decideStep(1), decideStep(4),
+				after(30*time.Second, decideStep(1)), after(2*time.Minute, decideStep(3)),
+			},
+		},
+		{
+			name: "leaky_bucket",
+			pair: func(t *testing.T, addr string, now func() time.Time) (Limiter, Limiter) {
+				return scriptPair(t, addr, now, func(b bucket.Bucket[bucket.LeakyBucketType]) Limiter {
+					return NewLeakyBucketLimiter(b, LeakyBucketConfig{LeakRate: 4, QueueSize: 3})
+				})
+			},
+			steps: []scriptStep{
+				reserveStep("a", 1), reserveStep("b", 1), reserveStep("c", 1), reserveStep("d", 1), reserveStep("e", 1),
+				after(100*time.Millisecond, cancelStep("c")), reserveStep("f", 1), refundStep("a"), decideStep(1), chargeStep(2),
+				decideStep(1), after(500*time.Millisecond, reserveStep("g", 1)), after(3*time.Second, decideStep(1)),
+				after(250*time.Millisecond, decideStep(1)), after(10*time.Second, chargeStep(1)),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &stepClock{now: time.Now().Add(2 * time.Hour).Truncate(time.Hour)}
+			server := redistest.NewServer(t)
+			server.SetNow(clock.Now)
+			inMemory, scripted := tt.pair(t, server.Addr(), clock.Now)
+
+			// the scripts count in microseconds, which adds up over many intervals
+			near := func(a, b time.Duration) bool {
+				return a-b <= time.Millisecond && b-a <= time.Millisecond
+			}
+			memReservations, scriptReservations := map[string]*Reservation{}, map[string]*Reservation{}
+			for i, step := range tt.steps {
+				clock.advance(step.advance)
+				want := step.run(inMemory, clock, memReservations)
+				got := step.run(scripted, clock, scriptReservations)
+
+				if got.Allowed != want.Allowed || got.Limit != want.Limit || got.Remaining != want.Remaining || got.Window != want.Window ||
+					!near(got.RetryAfter, want.RetryAfter) || !near(got.ResetAt.Sub(want.ResetAt), 0) {
+					t.Errorf("step %d, %s: the script decided %+v, the in-memory limiter %+v", i, step.desc, got, want)
+				}
+			}
+		})
+	}
+}
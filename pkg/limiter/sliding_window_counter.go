@@ -0,0 +1,282 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type SlidingWindowCounterConfig struct {
+	WindowDuration time.Duration // duration of the window, seconds, minutes etc
+	WindowTokens   int           // number of tokens per window
+	WindowSize     int           // number to multiply the duration by
+}
+
+// SlidingWindowCounterLimiter approximates a sliding window by weighting the
+// previous window's count by how much of it still overlaps the sliding window
+// and adding the current window's count. It only keeps two counters per key.
+type SlidingWindowCounterLimiter struct {
+	bucket         bucket.Bucket[bucket.SlidingWindowCounterBucketType]
+	WindowDuration time.Duration
+	WindowSize     int
+	WindowTokens   int
+	runner         bucket.ScriptRunner // runs slidingWindowCounterScript if the bucket can
+	now            func() time.Time
+}
+
+func init() {
+	RegisterLimiter("sliding_window_counter", func(cfg map[string]any) Limiter {
+		return NewSlidingWindowCounterLimiter(bucket.NewInMemoryBucket[bucket.SlidingWindowCounterBucketType](bucket.WithJanitor(janitorInterval)), SlidingWindowCounterConfig{
+			WindowDuration: cfg["window_duration"].(time.Duration),
+			WindowTokens:   cfg["window_tokens"].(int),
+			WindowSize:     cfg["window_size"].(int),
+		})
+	})
+}
+
+// NewSlidingWindowCounterLimiter creates a new SlidingWindowCounterLimiter with the given bucket and config.
+// If the config's WindowSize, WindowTokens or WindowDuration is 0, it will be set to the default values of 1, 5 and 1s respectively.
+func NewSlidingWindowCounterLimiter(swcBucket bucket.Bucket[bucket.SlidingWindowCounterBucketType], swcConfig SlidingWindowCounterConfig) *SlidingWindowCounterLimiter {
+	if swcConfig.WindowSize == 0 {
+		swcConfig.WindowSize = 1
+	}
+
+	if swcConfig.WindowTokens == 0 {
+		swcConfig.WindowTokens = 5
+	}
+
+	if swcConfig.WindowDuration == 0 {
+		swcConfig.WindowDuration = time.Second
+	}
+
+	return &SlidingWindowCounterLimiter{
+		bucket:         swcBucket,
+		WindowDuration: swcConfig.WindowDuration,
+		WindowTokens:   swcConfig.WindowTokens,
+		WindowSize:     swcConfig.WindowSize,
+		runner:         scriptRunner(swcBucket),
+		now:            time.Now,
+	}
+}
+
+// Allow returns true if the weighted count of the previous and current window
+// leaves room for one more request, false otherwise.
+func (s *SlidingWindowCounterLimiter) Allow(key string) bool {
+	return s.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow but counts n requests at once.
+func (s *SlidingWindowCounterLimiter) AllowN(key string, n int) bool {
+	return s.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports the room left under the weighted
+// count and, if denied, when the previous windows have decayed enough for the
+// next request.
+func (s *SlidingWindowCounterLimiter) Decide(key string) Decision {
+	return s.DecideN(key, 1)
+}
+
+// DecideN works like Decide but counts n requests at once. A cost above
+// WindowTokens is never allowed.
+func (s *SlidingWindowCounterLimiter) DecideN(key string, n int) Decision {
+	return s.decideAt(key, normalizeCost(n), s.now())
+}
+
+// Reserve counts n requests in the current window. Requests can't be reserved
+// in future windows, so the reservation is either usable right away or not
+// OK, in which case its Decision's RetryAfter tells when n requests fit.
+func (s *SlidingWindowCounterLimiter) Reserve(key string, n int) *Reservation {
+	return s.reserveAt(key, normalizeCost(n), s.now())
+}
+
+// Wait blocks until key has capacity for one request or ctx is done. It fails
+// with ErrWaitExceedsDeadline right away if the wait would run past ctx's
+// deadline.
+func (s *SlidingWindowCounterLimiter) Wait(ctx context.Context, key string) error {
+	return WaitN(ctx, s, key, 1)
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (s *SlidingWindowCounterLimiter) Close() error {
+	return closeBucket(s.bucket)
+}
+
+func (s *SlidingWindowCounterLimiter) decideAt(key string, n int, now time.Time) Decision {
+	return s.reserveAt(key, n, now).Decision()
+}
+
+func (s *SlidingWindowCounterLimiter) reserveAt(key string, n int, now time.Time) *Reservation {
+	windowLength := int64(time.Duration(s.WindowSize) * s.WindowDuration)
+	if s.runner != nil {
+		return reserveByScript(s.runner, slidingWindowCounterScript, key, n, now, 0, Decision{Limit: s.WindowTokens, Window: time.Duration(windowLength)}, s.WindowTokens, time.Duration(windowLength))
+	}
+	currentWindow := now.UnixNano() / windowLength
+	windowStart := time.Unix(0, currentWindow*windowLength)
+
+	var r *Reservation
+	err := s.bucket.Update(key, func(swc *bucket.SlidingWindowCounterBucketType) (*bucket.SlidingWindowCounterBucketType, error) {
+		swc = rollWindow(swc, currentWindow, windowLength)
+		weighted := weightedCount(swc, now, windowLength)
+
+		allowed := false
+		if weighted+float64(n) <= float64(s.WindowTokens) {
+			swc.CurrentCount += n
+			weighted += float64(n)
+			allowed = true
+		}
+
+		decision := Decision{
+			Allowed:   allowed,
+			Limit:     s.WindowTokens,
+			Remaining: max(int(float64(s.WindowTokens)-weighted), 0),
+			ResetAt:   now,
+			Window:    time.Duration(windowLength),
+		}
+		// requests in the current window keep counting until the end of the next
+		// one, the previous window stops counting when the current one ends
+		decision.ResetAt = counterResetAt(swc, windowStart, windowLength, now)
+
+		if !allowed {
+			if n <= s.WindowTokens {
+				decision.RetryAfter = s.retryAt(swc, n, windowStart, windowLength).Sub(now)
+			}
+			r = DeniedReservation(decision)
+			return swc, nil
+		}
+
+		r = NewReservation(decision, now, func() {
+			s.refund(key, currentWindow, n)
+		})
+		return swc, nil
+	})
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(Decision{Limit: s.WindowTokens, Window: time.Duration(windowLength)})
+	}
+
+	return r
+}
+
+// Charge counts n requests in the current window, even if that puts the
+// weighted count over WindowTokens. The key's next request is allowed once
+// enough of the count has slid out of the window.
+func (s *SlidingWindowCounterLimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	windowLength := int64(time.Duration(s.WindowSize) * s.WindowDuration)
+	now := s.now()
+	if s.runner != nil {
+		return chargeByScript(s.runner, slidingWindowCounterScript, key, n, now, Decision{Limit: s.WindowTokens, Window: time.Duration(windowLength)}, s.WindowTokens, time.Duration(windowLength))
+	}
+	currentWindow := now.UnixNano() / windowLength
+	windowStart := time.Unix(0, currentWindow*windowLength)
+
+	var decision Decision
+	err := s.bucket.Update(key, func(swc *bucket.SlidingWindowCounterBucketType) (*bucket.SlidingWindowCounterBucketType, error) {
+		swc = rollWindow(swc, currentWindow, windowLength)
+		swc.CurrentCount += n
+		weighted := weightedCount(swc, now, windowLength)
+
+		decision = Decision{
+			Allowed:   weighted+1 <= float64(s.WindowTokens),
+			Limit:     s.WindowTokens,
+			Remaining: max(int(float64(s.WindowTokens)-weighted), 0),
+			ResetAt:   counterResetAt(swc, windowStart, windowLength, now),
+			Window:    time.Duration(windowLength),
+		}
+		if !decision.Allowed {
+			decision.RetryAfter = s.retryAt(swc, 1, windowStart, windowLength).Sub(now)
+		}
+		return swc, nil
+	})
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return Decision{Limit: s.WindowTokens, Window: time.Duration(windowLength)}
+	}
+
+	return decision
+}
+
+// rollWindow returns the key's counters, created if the key doesn't exist and
+// rolled over if we moved into a new window. The previous count only carries
+// over if the stored window is the one right before this one.
+func rollWindow(swc *bucket.SlidingWindowCounterBucketType, currentWindow int64, windowLength int64) *bucket.SlidingWindowCounterBucketType {
+	// check if the key exists
+	if swc == nil {
+		swc = &bucket.SlidingWindowCounterBucketType{
+			CurrentWindow: currentWindow,
+		}
+	}
+	swc.WindowLength = time.Duration(windowLength)
+
+	if swc.CurrentWindow == currentWindow {
+		return swc
+	}
+	if swc.CurrentWindow == currentWindow-1 {
+		swc.PreviousCount = swc.CurrentCount
+	} else {
+		swc.PreviousCount = 0
+	}
+	swc.CurrentCount = 0
+	swc.CurrentWindow = currentWindow
+	return swc
+}
+
+// weightedCount returns the requests counted in the sliding window ending
+// now. The fraction of the current window that has already elapsed is the
+// part of the previous window that slid out.
+func weightedCount(swc *bucket.SlidingWindowCounterBucketType, now time.Time, windowLength int64) float64 {
+	elapsed := float64(now.UnixNano()%windowLength) / float64(windowLength)
+	return float64(swc.PreviousCount)*(1-elapsed) + float64(swc.CurrentCount)
+}
+
+// counterResetAt returns the time nothing counts against the key anymore:
+// requests in the current window keep counting until the end of the next
+// one, the previous window stops counting when the current one ends
+func counterResetAt(swc *bucket.SlidingWindowCounterBucketType, windowStart time.Time, windowLength int64, now time.Time) time.Time {
+	if swc.CurrentCount > 0 {
+		return windowStart.Add(2 * time.Duration(windowLength))
+	}
+	if swc.PreviousCount > 0 {
+		return windowStart.Add(time.Duration(windowLength))
+	}
+	return now
+}
+
+// refund takes n requests back off the count of the window they were counted
+// in, which may have become the previous window since.
+func (s *SlidingWindowCounterLimiter) refund(key string, window int64, n int) {
+	s.bucket.Update(key, func(swc *bucket.SlidingWindowCounterBucketType) (*bucket.SlidingWindowCounterBucketType, error) {
+		if swc == nil {
+			return nil, nil
+		}
+
+		switch window {
+		case swc.CurrentWindow:
+			swc.CurrentCount = max(swc.CurrentCount-n, 0)
+		case swc.CurrentWindow - 1:
+			swc.PreviousCount = max(swc.PreviousCount-n, 0)
+		default:
+			return nil, nil
+		}
+		return swc, nil
+	})
+}
+
+// retryAt returns the first time the weighted count leaves room for n more
+// requests, assuming no more requests are allowed in between.
+func (s *SlidingWindowCounterLimiter) retryAt(swc *bucket.SlidingWindowCounterBucketType, n int, windowStart time.Time, windowLength int64) time.Time {
+	free := float64(s.WindowTokens - swc.CurrentCount - n)
+	if free >= 0 && swc.PreviousCount > 0 {
+		// wait for enough of the previous window to slide out
+		elapsed := 1 - free/float64(swc.PreviousCount)
+		return windowStart.Add(time.Duration(math.Ceil(elapsed * float64(windowLength))))
+	}
+
+	// the current window alone is full, it has to slide out in the next window
+	elapsed := 1 - float64(s.WindowTokens-n)/float64(swc.CurrentCount)
+	return windowStart.Add(time.Duration(windowLength) + time.Duration(math.Ceil(elapsed*float64(windowLength))))
+}
@@ -0,0 +1,201 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type mockSlidingWindowCounterBucket struct {
+	store map[string]*bucket.SlidingWindowCounterBucketType
+}
+
+func (m *mockSlidingWindowCounterBucket) Get(key string) *bucket.SlidingWindowCounterBucketType {
+	return m.store[key]
+}
+
+func (m *mockSlidingWindowCounterBucket) Set(key string, bucket *bucket.SlidingWindowCounterBucketType) error {
+	m.store[key] = bucket
+	return nil
+}
+
+func (m *mockSlidingWindowCounterBucket) Update(key string, fn bucket.UpdateFunc[bucket.SlidingWindowCounterBucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
+func (m *mockSlidingWindowCounterBucket) Delete(key string) error {
+	delete(m.store, key)
+	return nil
+}
+
+func (m *mockSlidingWindowCounterBucket) Clear() {
+	m.store = make(map[string]*bucket.SlidingWindowCounterBucketType)
+}
+
+func TestNewSlidingWindowCounterLimiter_Defaults(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{})
+
+	if limiter.WindowSize != 1 {
+		t.Errorf("expected WindowSize to be 1, got %d", limiter.WindowSize)
+	}
+
+	if limiter.WindowTokens != 5 {
+		t.Errorf("expected WindowTokens to be 5, got %d", limiter.WindowTokens)
+	}
+
+	if limiter.WindowDuration != time.Second {
+		t.Errorf("expected WindowDuration to be 1s, got %s", limiter.WindowDuration)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Allow_NewKey(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{})
+
+	key := "newkey"
+	allowed := limiter.Allow(key)
+	if !allowed {
+		t.Errorf("expected Allow to return true for new key")
+	}
+
+	swc := mockBucket.Get(key)
+	if swc == nil {
+		t.Fatalf("expected counter bucket to be created")
+	}
+
+	if swc.CurrentCount != 1 {
+		t.Errorf("expected CurrentCount=1 for new key, got %d", swc.CurrentCount)
+	}
+
+	if swc.CurrentWindow == 0 {
+		t.Errorf("expected CurrentWindow to be set for new key, got %d", swc.CurrentWindow)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Allow_Burst(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{
+		WindowDuration: time.Minute,
+	})
+
+	key := "burstkey"
+	now := time.Unix(0, 0).Add(time.Hour)
+
+	// First 5 calls should be allowed
+	for i := 1; i <= 5; i++ {
+		if !limiter.decideAt(key, 1, now).Allowed {
+			t.Errorf("expected Allow to return true on call %d", i)
+		}
+	}
+
+	// Next call should be denied
+	if limiter.decideAt(key, 1, now).Allowed {
+		t.Errorf("expected Allow to return false after burst")
+	}
+
+	swc := mockBucket.Get(key)
+	if swc.CurrentCount != 5 {
+		t.Errorf("expected CurrentCount still=5, got %d", swc.CurrentCount)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Allow_WeightsPreviousWindow(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   10,
+	})
+
+	key := "weightedkey"
+	windowStart := time.Unix(0, 0).Add(time.Hour)
+
+	// Fill the whole previous window
+	for i := 1; i <= 10; i++ {
+		limiter.decideAt(key, 1, windowStart.Add(-time.Second))
+	}
+
+	// A quarter into the next window, 75% of the previous window still counts:
+	// 10 * 0.75 = 7.5, so only 2 more requests fit
+	at := windowStart.Add(15 * time.Second)
+	for i := 1; i <= 2; i++ {
+		if !limiter.decideAt(key, 1, at).Allowed {
+			t.Errorf("expected Allow to return true on call %d", i)
+		}
+	}
+	if limiter.decideAt(key, 1, at).Allowed {
+		t.Errorf("expected Allow to return false once weighted count reaches the limit")
+	}
+
+	swc := mockBucket.Get(key)
+	if swc.PreviousCount != 10 {
+		t.Errorf("expected PreviousCount=10, got %d", swc.PreviousCount)
+	}
+	if swc.CurrentCount != 2 {
+		t.Errorf("expected CurrentCount=2, got %d", swc.CurrentCount)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Allow_DropsStaleWindow(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{
+		WindowDuration: time.Minute,
+	})
+
+	key := "stalekey"
+	start := time.Unix(0, 0).Add(time.Hour)
+
+	// Exhaust the window
+	for i := 1; i <= 6; i++ {
+		limiter.decideAt(key, 1, start)
+	}
+
+	// Two windows later nothing from the old window should count
+	if !limiter.decideAt(key, 1, start.Add(2*time.Minute)).Allowed {
+		t.Errorf("expected Allow to return true after a full idle window")
+	}
+
+	swc := mockBucket.Get(key)
+	if swc.PreviousCount != 0 {
+		t.Errorf("expected PreviousCount=0, got %d", swc.PreviousCount)
+	}
+	if swc.CurrentCount != 1 {
+		t.Errorf("expected CurrentCount=1, got %d", swc.CurrentCount)
+	}
+}
+
+func TestSlidingWindowCounterLimiter_Decide_RetryAfter(t *testing.T) {
+	mockBucket := &mockSlidingWindowCounterBucket{store: make(map[string]*bucket.SlidingWindowCounterBucketType)}
+	limiter := NewSlidingWindowCounterLimiter(mockBucket, SlidingWindowCounterConfig{
+		WindowDuration: time.Minute,
+		WindowTokens:   10,
+	})
+
+	key := "retrykey"
+	windowStart := time.Unix(0, 0).Add(time.Hour)
+
+	for i := 1; i <= 10; i++ {
+		limiter.decideAt(key, 1, windowStart.Add(-time.Second))
+	}
+
+	// 10 * (1 - f) + 1 <= 10 once f >= 0.1, i.e. 6s into the window
+	at := windowStart.Add(time.Second)
+	decision := limiter.decideAt(key, 1, at)
+	if decision.Allowed {
+		t.Fatalf("expected Decide to deny while the previous window still counts")
+	}
+	if decision.RetryAfter != 5*time.Second {
+		t.Errorf("expected RetryAfter=5s, got %s", decision.RetryAfter)
+	}
+	if !limiter.decideAt(key, 1, at.Add(decision.RetryAfter)).Allowed {
+		t.Errorf("expected request to be allowed after RetryAfter")
+	}
+}
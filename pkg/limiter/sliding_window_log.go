@@ -1,6 +1,7 @@
 package limiter
 
 import (
+	"context"
 	"time"
 
 	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
@@ -17,11 +18,13 @@ type SlidingWindowLogLimiter struct {
 	Capacity       int
 	WindowSize     int64
 	WindowDuration time.Duration
+	runner         bucket.ScriptRunner // runs slidingWindowLogScript if the bucket can
+	now            func() time.Time
 }
 
 func init() {
 	RegisterLimiter("sliding_window_log", func(cfg map[string]any) Limiter {
-		return NewSlidingWindowLogLimiter(bucket.NewInMemoryBucket[bucket.SlidingWindowLogBucketType](), SlidingWindowLogConfig{
+		return NewSlidingWindowLogLimiter(bucket.NewInMemoryBucket[bucket.SlidingWindowLogBucketType](bucket.WithJanitor(janitorInterval)), SlidingWindowLogConfig{
 			WindowSize:     cfg["window_size"].(int64),
 			Capacity:       cfg["capacity"].(int),
 			WindowDuration: cfg["window_duration"].(time.Duration),
@@ -48,36 +51,210 @@ func NewSlidingWindowLogLimiter(swBucket bucket.Bucket[bucket.SlidingWindowLogBu
 		Capacity:       config.Capacity,
 		WindowSize:     config.WindowSize,
 		WindowDuration: config.WindowDuration,
+		runner:         scriptRunner(swBucket),
+		now:            time.Now,
 	}
 }
 
 func (s *SlidingWindowLogLimiter) Allow(key string) bool {
+	return s.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow but logs a single entry weighing n tokens.
+func (s *SlidingWindowLogLimiter) AllowN(key string, n int) bool {
+	return s.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports the room left in the log and,
+// if denied, when the oldest entry standing in the way expires.
+func (s *SlidingWindowLogLimiter) Decide(key string) Decision {
+	return s.DecideN(key, 1)
+}
+
+// DecideN works like Decide but logs an entry weighing n tokens. The entry is
+// only added if the total weight in the window stays within Capacity, a cost
+// above Capacity is never allowed.
+func (s *SlidingWindowLogLimiter) DecideN(key string, n int) Decision {
+	return s.reserve(key, normalizeCost(n), s.now(), 0).Decision()
+}
+
+// Reserve logs an entry weighing n tokens at the first time it fits in the
+// window. If that's in the future the entry already counts against the key
+// until it expires, and the reservation's Delay is the time until it fits.
+func (s *SlidingWindowLogLimiter) Reserve(key string, n int) *Reservation {
+	return s.reserve(key, normalizeCost(n), s.now(), InfDuration)
+}
+
+// Wait blocks until key has capacity for one request or ctx is done. It fails
+// with ErrWaitExceedsDeadline right away if the wait would run past ctx's
+// deadline.
+func (s *SlidingWindowLogLimiter) Wait(ctx context.Context, key string) error {
+	return WaitN(ctx, s, key, 1)
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (s *SlidingWindowLogLimiter) Close() error {
+	return closeBucket(s.bucket)
+}
+
+func (s *SlidingWindowLogLimiter) reserve(key string, n int, now time.Time, maxWait time.Duration) *Reservation {
+	window := time.Duration(s.WindowSize) * s.WindowDuration
+	if s.runner != nil {
+		return reserveByScript(s.runner, slidingWindowLogScript, key, n, now, maxWait, Decision{Limit: s.Capacity, Window: window}, s.Capacity, window)
+	}
+
+	var r *Reservation
+	err := s.bucket.Update(key, func(swl *bucket.SlidingWindowLogBucketType) (*bucket.SlidingWindowLogBucketType, error) {
+		swl, used := trimLog(swl, window, now)
+		newWindowLog := swl.WindowLog
+
+		decision := Decision{
+			Limit:     s.Capacity,
+			Remaining: max(s.Capacity-used, 0),
+			ResetAt:   logResetAt(newWindowLog, window, now),
+			Window:    window,
+		}
+
+		// more than the log can ever hold
+		if n > s.Capacity {
+			r = DeniedReservation(decision)
+			return swl, nil
+		}
+
+		timeToAct := s.fitsAt(newWindowLog, used, n, window, now)
+		wait := timeToAct.Sub(now)
+		if wait > maxWait {
+			decision.RetryAfter = wait
+			r = DeniedReservation(decision)
+			return swl, nil
+		}
+
+		entry := bucket.LogEntry{Timestamp: timeToAct, Cost: n}
+		swl.WindowLog = insertLogEntry(newWindowLog, entry)
+
+		decision.Allowed = wait == 0
+		decision.Remaining = max(decision.Remaining-n, 0)
+		decision.ResetAt = logResetAt(swl.WindowLog, window, now)
+		decision.RetryAfter = wait
+		r = NewReservation(decision, timeToAct, func() {
+			s.refund(key, entry)
+		})
+		return swl, nil
+	})
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(Decision{Limit: s.Capacity, Window: window})
+	}
+
+	return r
+}
+
+// Charge logs an entry weighing n tokens right away, even if that puts the
+// total weight in the window over Capacity. The key's next request is allowed
+// once enough entries have expired.
+func (s *SlidingWindowLogLimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	window := time.Duration(s.WindowSize) * s.WindowDuration
+	now := s.now()
+	if s.runner != nil {
+		return chargeByScript(s.runner, slidingWindowLogScript, key, n, now, Decision{Limit: s.Capacity, Window: window}, s.Capacity, window)
+	}
+
+	var decision Decision
+	err := s.bucket.Update(key, func(swl *bucket.SlidingWindowLogBucketType) (*bucket.SlidingWindowLogBucketType, error) {
+		swl, used := trimLog(swl, window, now)
+		used += n
+		swl.WindowLog = insertLogEntry(swl.WindowLog, bucket.LogEntry{Timestamp: now, Cost: n})
+
+		retryAfter := s.fitsAt(swl.WindowLog, used, 1, window, now).Sub(now)
+		decision = Decision{
+			Allowed:    retryAfter <= 0,
+			Limit:      s.Capacity,
+			Remaining:  max(s.Capacity-used, 0),
+			ResetAt:    logResetAt(swl.WindowLog, window, now),
+			RetryAfter: max(retryAfter, 0),
+			Window:     window,
+		}
+		return swl, nil
+	})
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return Decision{Limit: s.Capacity, Window: window}
+	}
+
+	return decision
+}
+
+// trimLog returns the key's log, created if the key doesn't exist, without the
+// entries that are no longer in the window, along with the total weight of the
+// entries left.
+func trimLog(swl *bucket.SlidingWindowLogBucketType, window time.Duration, now time.Time) (*bucket.SlidingWindowLogBucketType, int) {
 	// check if key exists
-	swl := s.bucket.Get(key)
 	if swl == nil {
-		swl = &bucket.SlidingWindowLogBucketType{
-			WindowLog: make([]time.Time, 0),
-		}
-		s.bucket.Set(key, swl)
+		swl = &bucket.SlidingWindowLogBucketType{}
 	}
 
-	now := time.Now()
-	// check if it's in the current window
+	// drop the entries that are no longer in the window
+	used := 0
+	windowLog := make([]bucket.LogEntry, 0, len(swl.WindowLog)+1)
+	for _, entry := range swl.WindowLog {
+		if entry.Timestamp.Add(window).After(now) {
+			windowLog = append(windowLog, entry)
+			used += entry.Cost
+		}
+	}
+	swl.WindowLog = windowLog
+	swl.Window = window
+	return swl, used
+}
 
-	newWindowLog := make([]time.Time, 0)
-	for _, t := range swl.WindowLog {
-		if t.Add(time.Duration(s.WindowSize) * s.WindowDuration).After(now) {
-			newWindowLog = append(newWindowLog, t)
+// fitsAt returns the first time an entry weighing n fits in the log, once
+// enough of the oldest entries expired. used is the total weight of the log.
+func (s *SlidingWindowLogLimiter) fitsAt(log []bucket.LogEntry, used, n int, window time.Duration, now time.Time) time.Time {
+	at := now
+	for _, entry := range log {
+		if used+n <= s.Capacity {
+			break
 		}
+		used -= entry.Cost
+		at = entry.Timestamp.Add(window)
 	}
+	return at
+}
+
+// refund removes a reserved entry from the key's log.
+func (s *SlidingWindowLogLimiter) refund(key string, entry bucket.LogEntry) {
+	s.bucket.Update(key, func(swl *bucket.SlidingWindowLogBucketType) (*bucket.SlidingWindowLogBucketType, error) {
+		if swl == nil {
+			return nil, nil
+		}
 
-	if len(newWindowLog) < s.Capacity {
-		newWindowLog = append(newWindowLog, time.Now())
+		for i, e := range swl.WindowLog {
+			if e.Timestamp.Equal(entry.Timestamp) && e.Cost == entry.Cost {
+				swl.WindowLog = append(swl.WindowLog[:i:i], swl.WindowLog[i+1:]...)
+				return swl, nil
+			}
+		}
+		return nil, nil
+	})
+}
 
-		swl.WindowLog = newWindowLog
-		s.bucket.Set(key, swl)
-		return true
+// insertLogEntry adds entry to the log keeping it ordered by timestamp, so
+// entries always expire oldest first.
+func insertLogEntry(log []bucket.LogEntry, entry bucket.LogEntry) []bucket.LogEntry {
+	i := len(log)
+	for i > 0 && log[i-1].Timestamp.After(entry.Timestamp) {
+		i--
 	}
+	return append(log[:i:i], append([]bucket.LogEntry{entry}, log[i:]...)...)
+}
 
-	return false
+// logResetAt returns the time the log is empty again, which is when its
+// newest entry expires.
+func logResetAt(log []bucket.LogEntry, window time.Duration, now time.Time) time.Time {
+	if len(log) == 0 {
+		return now
+	}
+	return log[len(log)-1].Timestamp.Add(window)
 }
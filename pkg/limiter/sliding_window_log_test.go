@@ -0,0 +1,140 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+type mockSlidingWindowLogBucket struct {
+	store map[string]*bucket.SlidingWindowLogBucketType
+}
+
+func (m *mockSlidingWindowLogBucket) Get(key string) *bucket.SlidingWindowLogBucketType {
+	return m.store[key]
+}
+
+func (m *mockSlidingWindowLogBucket) Set(key string, bucket *bucket.SlidingWindowLogBucketType) error {
+	m.store[key] = bucket
+	return nil
+}
+
+func (m *mockSlidingWindowLogBucket) Update(key string, fn bucket.UpdateFunc[bucket.SlidingWindowLogBucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
+func (m *mockSlidingWindowLogBucket) Delete(key string) error {
+	delete(m.store, key)
+	return nil
+}
+
+func (m *mockSlidingWindowLogBucket) Clear() {
+	m.store = make(map[string]*bucket.SlidingWindowLogBucketType)
+}
+
+func TestSlidingWindowLogLimiter_Allow_Burst(t *testing.T) {
+	mockBucket := &mockSlidingWindowLogBucket{store: make(map[string]*bucket.SlidingWindowLogBucketType)}
+	limiter := NewSlidingWindowLogLimiter(mockBucket, SlidingWindowLogConfig{})
+
+	key := "burstkey"
+
+	// First 5 calls should be allowed
+	for i := 1; i <= 5; i++ {
+		if !limiter.Allow(key) {
+			t.Errorf("expected Allow to return true on call %d", i)
+		}
+	}
+
+	if limiter.Allow(key) {
+		t.Errorf("expected Allow to return false after burst")
+	}
+
+	swl := mockBucket.Get(key)
+	if len(swl.WindowLog) != 5 {
+		t.Errorf("expected 5 log entries, got %d", len(swl.WindowLog))
+	}
+}
+
+func TestSlidingWindowLogLimiter_AllowN_WeightedEntries(t *testing.T) {
+	mockBucket := &mockSlidingWindowLogBucket{store: make(map[string]*bucket.SlidingWindowLogBucketType)}
+	limiter := NewSlidingWindowLogLimiter(mockBucket, SlidingWindowLogConfig{
+		Capacity:       10,
+		WindowDuration: time.Minute,
+	})
+
+	key := "weightedkey"
+	now := time.Now()
+
+	// An entry weighing 6 that expires in 10s and one weighing 3 that expires in 30s
+	mockBucket.Set(key, &bucket.SlidingWindowLogBucketType{
+		WindowLog: []bucket.LogEntry{
+			{Timestamp: now.Add(-50 * time.Second), Cost: 6},
+			{Timestamp: now.Add(-30 * time.Second), Cost: 3},
+		},
+	})
+
+	if !limiter.AllowN(key, 1) {
+		t.Errorf("expected AllowN(1) to fit in the remaining weight")
+	}
+
+	decision := limiter.DecideN(key, 4)
+	if decision.Allowed {
+		t.Fatalf("expected DecideN(4) to be denied with a full log")
+	}
+	if decision.Remaining != 0 {
+		t.Errorf("expected remaining=0, got %d", decision.Remaining)
+	}
+	// only the first entry has to expire to make room for 4
+	if decision.RetryAfter <= 9*time.Second || decision.RetryAfter > 10*time.Second {
+		t.Errorf("expected RetryAfter of about 10s, got %s", decision.RetryAfter)
+	}
+
+	swl := mockBucket.Get(key)
+	if len(swl.WindowLog) != 3 || swl.WindowLog[2].Cost != 1 {
+		t.Errorf("expected the weighted entry to be logged once, got %+v", swl.WindowLog)
+	}
+}
+
+func TestSlidingWindowLogLimiter_Reserve(t *testing.T) {
+	mockBucket := &mockSlidingWindowLogBucket{store: make(map[string]*bucket.SlidingWindowLogBucketType)}
+	limiter := NewSlidingWindowLogLimiter(mockBucket, SlidingWindowLogConfig{
+		Capacity:       2,
+		WindowDuration: time.Minute,
+	})
+
+	key := "reservekey"
+	now := time.Now()
+	mockBucket.Set(key, &bucket.SlidingWindowLogBucketType{
+		WindowLog: []bucket.LogEntry{
+			{Timestamp: now.Add(-40 * time.Second), Cost: 1},
+			{Timestamp: now.Add(-10 * time.Second), Cost: 1},
+		},
+	})
+
+	// The log is full, the entry is logged when the oldest one expires
+	r := limiter.Reserve(key, 1)
+	if !r.OK() {
+		t.Fatalf("expected reservation to be OK")
+	}
+	if delay := r.DelayFrom(now); delay != 20*time.Second {
+		t.Errorf("expected a delay of 20s, got %s", delay)
+	}
+
+	swl := mockBucket.Get(key)
+	if len(swl.WindowLog) != 3 || !swl.WindowLog[2].Timestamp.Equal(now.Add(20*time.Second)) {
+		t.Errorf("expected the reserved entry at the end of the log, got %+v", swl.WindowLog)
+	}
+
+	r.Cancel()
+	if len(swl.WindowLog) != 2 {
+		t.Errorf("expected cancel to remove the reserved entry, got %+v", swl.WindowLog)
+	}
+}
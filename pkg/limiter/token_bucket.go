@@ -1,6 +1,8 @@
 package limiter
 
 import (
+	"context"
+	"math"
 	"time"
 
 	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
@@ -16,11 +18,13 @@ type TokenBucketLimiter struct {
 	capacity   int
 	refillRate float64
 	tokens     int
+	runner     bucket.ScriptRunner // runs tokenBucketScript if the bucket can
+	now        func() time.Time
 }
 
 func init() {
 	RegisterLimiter("token_bucket", func(cfg map[string]any) Limiter {
-		return NewTokenBucketLimiter(bucket.NewInMemoryBucket[bucket.TokenBucketType](), BucketConfig{
+		return NewTokenBucketLimiter(bucket.NewInMemoryBucket[bucket.TokenBucketType](bucket.WithJanitor(janitorInterval)), BucketConfig{
 			Capacity:   cfg["capacity"].(int),
 			RefillRate: cfg["refill_rate"].(float64),
 			Tokens:     cfg["tokens"].(int),
@@ -47,6 +51,8 @@ func NewTokenBucketLimiter(tokenBucket bucket.Bucket[bucket.TokenBucketType], bu
 		capacity:   bucketConfig.Capacity,
 		refillRate: bucketConfig.RefillRate,
 		tokens:     bucketConfig.Tokens,
+		runner:     scriptRunner(tokenBucket),
+		now:        time.Now,
 	}
 }
 
@@ -55,40 +61,221 @@ func NewTokenBucketLimiter(tokenBucket bucket.Bucket[bucket.TokenBucketType], bu
 // If the key exists, it will check the elapsed time since last refill and add tokens accordingly.
 // It will then deduct a token for this request and return true if the key is allowed, false otherwise.
 func (tb *TokenBucketLimiter) Allow(key string) bool {
-	now := time.Now()
-	// get bucket from bucket store
-	tokenBucket := tb.bucket.Get(key)
+	return tb.DecideN(key, 1).Allowed
+}
+
+// AllowN works like Allow but deducts n tokens at once.
+// It returns false without deducting anything if fewer than n tokens are left.
+func (tb *TokenBucketLimiter) AllowN(key string, n int) bool {
+	return tb.DecideN(key, n).Allowed
+}
+
+// Decide works like Allow but also reports the tokens left in the bucket,
+// when it will be full again and, if denied, when the next token is refilled.
+func (tb *TokenBucketLimiter) Decide(key string) Decision {
+	return tb.DecideN(key, 1)
+}
+
+// DecideN works like Decide but deducts n tokens at once. If denied,
+// RetryAfter is the time until n tokens are refilled; a cost above the
+// bucket's capacity is never allowed.
+func (tb *TokenBucketLimiter) DecideN(key string, n int) Decision {
+	return tb.reserve(key, normalizeCost(n), tb.now(), 0).Decision()
+}
+
+// Reserve reserves n tokens for key. If the bucket doesn't hold n tokens yet
+// the tokens are borrowed from future refills and the reservation's Delay is
+// the time until they are refilled. A cost above the bucket's capacity can't
+// be reserved.
+func (tb *TokenBucketLimiter) Reserve(key string, n int) *Reservation {
+	return tb.reserve(key, normalizeCost(n), tb.now(), InfDuration)
+}
+
+// Wait blocks until key has capacity for one request or ctx is done. It fails
+// with ErrWaitExceedsDeadline right away if the wait would run past ctx's
+// deadline.
+func (tb *TokenBucketLimiter) Wait(ctx context.Context, key string) error {
+	return WaitN(ctx, tb, key, 1)
+}
+
+// Close closes the limiter's bucket if it holds resources, e.g. stops the
+// janitor of the buckets created by NewRateLimiter.
+func (tb *TokenBucketLimiter) Close() error {
+	return closeBucket(tb.bucket)
+}
+
+// Charge deducts n tokens from the key's bucket, letting it go negative if
+// there are too few. The bucket has to refill past zero before the key's
+// next request is allowed.
+func (tb *TokenBucketLimiter) Charge(key string, n int) Decision {
+	n = normalizeCost(n)
+	now := tb.now()
+	if tb.runner != nil {
+		return chargeByScript(tb.runner, tokenBucketScript, key, n, now, tb.limits(), tb.capacity, tb.refillRate, tb.tokens)
+	}
+
+	var decision Decision
+	err := tb.bucket.Update(key, func(tokenBucket *bucket.TokenBucketType) (*bucket.TokenBucketType, error) {
+		tokenBucket = tb.refill(tokenBucket, now)
+
+		tokenBucket.Tokens -= n
+
+		decision = Decision{
+			Allowed:   tokenBucket.Tokens >= 1,
+			Limit:     tokenBucket.Capacity,
+			Remaining: max(tokenBucket.Tokens, 0),
+			ResetAt:   tb.refilledAt(tokenBucket, tokenBucket.Capacity),
+			Window:    secondsToDuration(float64(tokenBucket.Capacity) / tokenBucket.RefillRate),
+		}
+		if !decision.Allowed {
+			decision.RetryAfter = tb.refilledAt(tokenBucket, 1).Sub(now)
+		}
+		return tokenBucket, nil
+	})
+	if err != nil {
+		// nothing was charged, report the key as exhausted
+		return tb.limits()
+	}
+
+	return decision
+}
+
+// reserve deducts n tokens from the key's bucket if they are refilled within
+// maxWait, letting the bucket go negative for tokens that are still missing.
+func (tb *TokenBucketLimiter) reserve(key string, n int, now time.Time, maxWait time.Duration) *Reservation {
+	if tb.runner != nil {
+		return reserveByScript(tb.runner, tokenBucketScript, key, n, now, maxWait, tb.limits(), tb.capacity, tb.refillRate, tb.tokens)
+	}
+
+	var r *Reservation
+	err := tb.bucket.Update(key, func(tokenBucket *bucket.TokenBucketType) (*bucket.TokenBucketType, error) {
+		tokenBucket = tb.refill(tokenBucket, now)
+
+		decision := Decision{
+			Limit:     tokenBucket.Capacity,
+			Remaining: max(tokenBucket.Tokens, 0),
+			ResetAt:   tb.refilledAt(tokenBucket, tokenBucket.Capacity),
+			Window:    secondsToDuration(float64(tokenBucket.Capacity) / tokenBucket.RefillRate),
+		}
+
+		// more than the bucket can ever hold
+		if n > tokenBucket.Capacity {
+			r = DeniedReservation(decision)
+			return tokenBucket, nil
+		}
 
-	// the key doesn't exist so create it and allow it
+		timeToAct := now
+		if tokenBucket.Tokens < n {
+			timeToAct = tb.refilledAt(tokenBucket, n)
+		}
+
+		wait := timeToAct.Sub(now)
+		if wait > maxWait {
+			decision.RetryAfter = wait
+			r = DeniedReservation(decision)
+			return tokenBucket, nil
+		}
+
+		// deduct the tokens for this request
+		tokenBucket.Tokens -= n
+		if timeToAct.After(tokenBucket.LastEvent) {
+			tokenBucket.LastEvent = timeToAct
+		}
+
+		decision.Allowed = wait == 0
+		decision.Remaining = max(tokenBucket.Tokens, 0)
+		decision.ResetAt = tb.refilledAt(tokenBucket, tokenBucket.Capacity)
+		decision.RetryAfter = wait
+		r = newReservation(decision, timeToAct, func(now time.Time) {
+			tb.cancel(key, n, timeToAct, now)
+		}, func() {
+			tb.refund(key, n)
+		})
+		return tokenBucket, nil
+	})
+	if err != nil {
+		// fail closed if the bucket store can't be updated
+		return DeniedReservation(tb.limits())
+	}
+
+	return r
+}
+
+// limits returns the decision fields that don't depend on the key's state
+func (tb *TokenBucketLimiter) limits() Decision {
+	return Decision{Limit: tb.capacity, Window: secondsToDuration(float64(tb.capacity) / tb.refillRate)}
+}
+
+// refill returns the key's bucket, created with the configured tokens if the
+// key doesn't exist, with the tokens refilled since its last refill added, up
+// to its capacity.
+func (tb *TokenBucketLimiter) refill(tokenBucket *bucket.TokenBucketType, now time.Time) *bucket.TokenBucketType {
 	if tokenBucket == nil {
-		tokenBucket = &bucket.TokenBucketType{
+		return &bucket.TokenBucketType{
 			Capacity:   tb.capacity,
 			RefillRate: tb.refillRate,
 			Tokens:     tb.tokens,
+			Initial:    tb.tokens,
 			LastRefill: now,
 		}
-
-		tb.bucket.Set(key, tokenBucket)
 	}
 
-	// if key exists check the elapsed time since last refill
-	elapsed := now.Sub(tokenBucket.LastRefill).Seconds()
-
-	// calculate how many tokens to add
-	addedTokens := int(elapsed * float64(tokenBucket.RefillRate))
-
+	addedTokens := int(now.Sub(tokenBucket.LastRefill).Seconds() * tokenBucket.RefillRate)
 	if addedTokens > 0 {
 		tokenBucket.Tokens = min(tokenBucket.Capacity, tokenBucket.Tokens+addedTokens)
 		tokenBucket.LastRefill = now
 	}
+	return tokenBucket
+}
+
+// cancel puts back the n tokens of a reservation due at timeToAct, less the
+// tokens reserved after it: those reservations were timed on the bucket
+// owing this one's tokens too.
+func (tb *TokenBucketLimiter) cancel(key string, n int, timeToAct time.Time, now time.Time) {
+	tb.bucket.Update(key, func(tokenBucket *bucket.TokenBucketType) (*bucket.TokenBucketType, error) {
+		if tokenBucket == nil {
+			return nil, nil
+		}
 
-	// deduct a token for this request
-	if tokenBucket.Tokens > 0 {
-		tokenBucket.Tokens--
+		reservedAfter := int(math.Round(tokenBucket.LastEvent.Sub(timeToAct).Seconds() * tokenBucket.RefillRate))
+		restore := n - reservedAfter
+		if restore <= 0 {
+			return nil, nil
+		}
+
+		tokenBucket = tb.refill(tokenBucket, now)
+		tokenBucket.Tokens = min(tokenBucket.Capacity, tokenBucket.Tokens+restore)
+
+		// the latest reservation is gone, the one before it is the latest now
+		if tokenBucket.LastEvent.Equal(timeToAct) {
+			if prev := timeToAct.Add(-secondsToDuration(float64(n) / tokenBucket.RefillRate)); !prev.Before(now) {
+				tokenBucket.LastEvent = prev
+			}
+		}
+		return tokenBucket, nil
+	})
+}
+
+// refund puts n tokens back in the key's bucket, up to its capacity.
+func (tb *TokenBucketLimiter) refund(key string, n int) {
+	tb.bucket.Update(key, func(tokenBucket *bucket.TokenBucketType) (*bucket.TokenBucketType, error) {
+		if tokenBucket == nil {
+			return nil, nil
+		}
+
+		tokenBucket.Tokens = min(tokenBucket.Capacity, tokenBucket.Tokens+n)
+		return tokenBucket, nil
+	})
+}
 
-		return true
+// refilledAt returns the time the bucket holds the given number of tokens,
+// counting from its last refill.
+func (tb *TokenBucketLimiter) refilledAt(tokenBucket *bucket.TokenBucketType, tokens int) time.Time {
+	missing := tokens - tokenBucket.Tokens
+	if missing <= 0 {
+		return tokenBucket.LastRefill
 	}
-	return false
+	return tokenBucket.LastRefill.Add(secondsToDuration(float64(missing) / tokenBucket.RefillRate))
 }
 
 func min(a, b int) int {
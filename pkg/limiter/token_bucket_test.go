@@ -29,6 +29,17 @@ func (m *mockBucket) Set(key string, tb *bucket.TokenBucketType) error {
 	return nil
 }
 
+func (m *mockBucket) Update(key string, fn bucket.UpdateFunc[bucket.TokenBucketType]) error {
+	next, err := fn(m.store[key])
+	if err != nil {
+		return err
+	}
+	if next != nil {
+		m.store[key] = next
+	}
+	return nil
+}
+
 // Delete removes the token bucket for the given key from the in-memory store.
 // It returns an error if there was a problem deleting the token bucket.
 func (m *mockBucket) Delete(key string) error {
@@ -245,6 +256,177 @@ func TestTokenBucketLimiter_Allow_FractionalRefill(t *testing.T) {
 	}
 }
 
+func TestTokenBucketLimiter_Decide(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   5,
+		RefillRate: 2,
+		Tokens:     5,
+	})
+
+	key := "decidekey"
+
+	decision := limiter.Decide(key)
+	if !decision.Allowed {
+		t.Errorf("expected Decide to allow new key")
+	}
+	if decision.Limit != 5 || decision.Remaining != 4 {
+		t.Errorf("expected limit=5 remaining=4, got limit=%d remaining=%d", decision.Limit, decision.Remaining)
+	}
+
+	tb := mockB.Get(key)
+	// one token missing at 2 tokens per second
+	if expected := tb.LastRefill.Add(500 * time.Millisecond); !decision.ResetAt.Equal(expected) {
+		t.Errorf("expected ResetAt=%v, got %v", expected, decision.ResetAt)
+	}
+
+	for i := 1; i <= 4; i++ {
+		limiter.Decide(key)
+	}
+
+	decision = limiter.Decide(key)
+	if decision.Allowed {
+		t.Errorf("expected Decide to deny an empty bucket")
+	}
+	if decision.Remaining != 0 {
+		t.Errorf("expected remaining=0, got %d", decision.Remaining)
+	}
+	if decision.RetryAfter <= 0 || decision.RetryAfter > 500*time.Millisecond {
+		t.Errorf("expected RetryAfter within the next refill (500ms), got %s", decision.RetryAfter)
+	}
+}
+
+func TestTokenBucketLimiter_AllowN(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   10,
+		RefillRate: 1,
+		Tokens:     10,
+	})
+
+	key := "weightedkey"
+
+	if !limiter.AllowN(key, 7) {
+		t.Errorf("expected AllowN(7) to be allowed with 10 tokens")
+	}
+
+	tb := mockB.Get(key)
+	if tb.Tokens != 3 {
+		t.Errorf("expected tokens=3, got %d", tb.Tokens)
+	}
+
+	// Not enough tokens left, nothing should be deducted
+	decision := limiter.DecideN(key, 5)
+	if decision.Allowed {
+		t.Errorf("expected DecideN(5) to be denied with 3 tokens")
+	}
+	if tb.Tokens != 3 {
+		t.Errorf("expected tokens still=3, got %d", tb.Tokens)
+	}
+	if decision.RetryAfter <= time.Second || decision.RetryAfter > 2*time.Second {
+		t.Errorf("expected RetryAfter until 2 more tokens are refilled, got %s", decision.RetryAfter)
+	}
+
+	if !limiter.AllowN(key, 3) {
+		t.Errorf("expected AllowN(3) to use the remaining tokens")
+	}
+}
+
+func TestTokenBucketLimiter_AllowN_AboveCapacity(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   5,
+		RefillRate: 1,
+		Tokens:     5,
+	})
+
+	key := "hugekey"
+
+	decision := limiter.DecideN(key, 6)
+	if decision.Allowed {
+		t.Errorf("expected a cost above capacity to be denied")
+	}
+	if decision.RetryAfter != 0 {
+		t.Errorf("expected no RetryAfter for a cost that can never fit, got %s", decision.RetryAfter)
+	}
+	if tb := mockB.Get(key); tb.Tokens != 5 {
+		t.Errorf("expected tokens untouched, got %d", tb.Tokens)
+	}
+}
+
+func TestTokenBucketLimiter_IdleKeyBelowCapacity(t *testing.T) {
+	clock := &stepClock{now: time.Now()}
+	memBucket := bucket.NewInMemoryBucket[bucket.TokenBucketType]()
+	defer memBucket.Close()
+	limiter := NewTokenBucketLimiter(clockedBucket[bucket.TokenBucketType]{Bucket: memBucket, now: clock.Now}, BucketConfig{
+		Capacity:   5,
+		RefillRate: 1,
+		Tokens:     2,
+	})
+	limiter.now = clock.Now
+
+	key := "idlekey"
+	if !limiter.AllowN(key, 2) {
+		t.Fatalf("expected a new key to start with 2 tokens")
+	}
+
+	// a cost above capacity is always denied and reports the tokens left
+	// without taking any. The key refills up to the tokens of a new key and
+	// expires there, it must not get back fewer tokens than it had.
+	remaining := 0
+	for i := 1; i <= 10; i++ {
+		clock.advance(time.Second)
+		d := limiter.DecideN(key, 6)
+		if d.Remaining < remaining {
+			t.Fatalf("after %ds: expected the idle key to keep its %d tokens, got %d", i, remaining, d.Remaining)
+		}
+		remaining = d.Remaining
+	}
+	if remaining != 2 {
+		t.Errorf("expected the idle key to end up with the 2 tokens of a new key, got %d", remaining)
+	}
+}
+
+func TestTokenBucketLimiter_Reserve(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   5,
+		RefillRate: 1,
+		Tokens:     5,
+	})
+
+	key := "reservekey"
+
+	r := limiter.Reserve(key, 5)
+	if !r.OK() || r.Delay() != 0 {
+		t.Errorf("expected a full bucket to be reserved right away, got ok=%v delay=%s", r.OK(), r.Delay())
+	}
+
+	// The bucket is empty, 2 tokens have to be borrowed from future refills
+	tb := mockB.Get(key)
+	r = limiter.Reserve(key, 2)
+	if !r.OK() {
+		t.Fatalf("expected reservation to be OK")
+	}
+	if delay := r.DelayFrom(tb.LastRefill); delay != 2*time.Second {
+		t.Errorf("expected a delay of 2s, got %s", delay)
+	}
+	if tb.Tokens != -2 {
+		t.Errorf("expected the bucket to owe 2 tokens, got %d", tb.Tokens)
+	}
+
+	// Cancelling gives the tokens back, only once
+	r.Cancel()
+	r.Cancel()
+	if tb.Tokens != 0 {
+		t.Errorf("expected tokens=0 after cancel, got %d", tb.Tokens)
+	}
+
+	if r := limiter.Reserve(key, 6); r.OK() || r.Delay() != InfDuration {
+		t.Errorf("expected a cost above capacity not to be reservable")
+	}
+}
+
 // type mockBucket struct {
 // 	store map[string]*bucket.TokenBucketType
 // }
@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrWaitExceedsDeadline is returned by Wait when the key won't have capacity
+// before the context's deadline, so there's no point in waiting for it.
+var ErrWaitExceedsDeadline = errors.New("Wait would exceed context deadline")
+
+// ErrCostExceedsLimit is returned by Wait when the request costs more than
+// the limiter can ever allow.
+var ErrCostExceedsLimit = errors.New("Cost exceeds limit")
+
+// WaitN blocks until n tokens are reserved for key on l and the reservation
+// can be acted on, or ctx is done. It fails right away if the wait would run
+// past ctx's deadline, and cancels the reservation if ctx is done while
+// waiting so no tokens are lost. Limiters registered outside this package can
+// use it to implement Wait.
+func WaitN(ctx context.Context, l Limiter, key string, n int) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		r := l.Reserve(key, n)
+		delay := r.Delay()
+		if !r.OK() {
+			// window based limiters can't reserve ahead, wait for the window
+			// to free up and try again
+			delay = r.Decision().RetryAfter
+			if delay <= 0 {
+				return ErrCostExceedsLimit
+			}
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			r.Cancel()
+			return ErrWaitExceedsDeadline
+		}
+
+		if delay == 0 {
+			return nil
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.Cancel()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		if r.OK() {
+			return nil
+		}
+	}
+}
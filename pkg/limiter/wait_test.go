@@ -0,0 +1,105 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Myspheet/go-rate-limiter/pkg/bucket"
+)
+
+func TestWait_BlocksUntilRefill(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   1,
+		RefillRate: 50,
+		Tokens:     1,
+	})
+
+	key := "waitkey"
+	start := time.Now()
+	for i := 1; i <= 2; i++ {
+		if err := limiter.Wait(context.Background(), key); err != nil {
+			t.Fatalf("expected Wait to succeed on call %d, got %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("expected the second call to wait for a refill, took %s", elapsed)
+	}
+}
+
+func TestWait_ExceedsDeadline(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   1,
+		RefillRate: 0.1,
+		Tokens:     1,
+	})
+
+	key := "deadlinekey"
+	limiter.Allow(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if err := limiter.Wait(ctx, key); !errors.Is(err, ErrWaitExceedsDeadline) {
+		t.Errorf("expected ErrWaitExceedsDeadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("expected Wait to fail without sleeping, took %s", elapsed)
+	}
+	if tb := mockB.Get(key); tb.Tokens != 0 {
+		t.Errorf("expected no tokens to be borrowed, got %d", tb.Tokens)
+	}
+}
+
+func TestWait_CancelReturnsTokens(t *testing.T) {
+	mockB := &mockBucket{store: make(map[string]*bucket.TokenBucketType)}
+	limiter := NewTokenBucketLimiter(mockB, BucketConfig{
+		Capacity:   1,
+		RefillRate: 0.1,
+		Tokens:     1,
+	})
+
+	key := "cancelkey"
+	limiter.Allow(key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if err := limiter.Wait(ctx, key); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if tb := mockB.Get(key); tb.Tokens != 0 {
+		t.Errorf("expected the reserved token to be given back, got %d", tb.Tokens)
+	}
+}
+
+func TestWait_WindowLimiterExceedsDeadline(t *testing.T) {
+	mockBucket := &mockFixedWindowBucket{store: make(map[string]*bucket.FixedWindowBucketType)}
+	limiter := NewFixedWindowLimiter(mockBucket, FixedWindowConfig{
+		WindowDuration: time.Hour,
+		WindowTokens:   1,
+	})
+
+	key := "windowkey"
+	limiter.Allow(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := limiter.Wait(ctx, key); !errors.Is(err, ErrWaitExceedsDeadline) {
+		t.Errorf("expected ErrWaitExceedsDeadline, got %v", err)
+	}
+}
+
+func TestWait_CostExceedsLimit(t *testing.T) {
+	mockBucket := &mockGCRABucket{store: make(map[string]*bucket.GCRABucketType)}
+	limiter := NewGCRALimiter(mockBucket, GCRAConfig{})
+
+	if err := WaitN(context.Background(), limiter, "costkey", limiter.Burst+1); !errors.Is(err, ErrCostExceedsLimit) {
+		t.Errorf("expected ErrCostExceedsLimit, got %v", err)
+	}
+}